@@ -2,171 +2,311 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/NieR8/myProject/internal/env"
+	"github.com/NieR8/myProject/internal/retry"
 	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/ops"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
 	"time"
 )
 
+const (
+	handBackTimeout = 5 * time.Second  // Сколько ждать ответа оркестратора, когда агент возвращает задачу
+	longPollWait    = 30 * time.Second // Сколько оркестратор держит запрос задачи, если готовых задач нет
+)
+
+var (
+	errPollTimeout    = errors.New("no task within the wait period")
+	errNotRegistered  = errors.New("agent is not registered")
+	errResultNotReady = errors.New("task result not available")
+	errExprFinished   = errors.New("expression already finished")
+)
+
 type Agent struct {
+	ID     string // Идентификатор агента для оркестратора, передаётся в заголовке X-Agent-ID
 	ind    int
-	Tasks  []chan models.Task
-	IsFree []bool
-	Work   []models.Task
 	Config env.Config
 	Client *http.Client
-	wg     sync.WaitGroup
-}
 
-func NewAgent() *Agent {
-	config := env.LoadConfig()
-	numWorkers := config.ComputingPower
+	queue   chan models.Task   // Общая очередь: Run кладёт задачу, её забирает свободный вычислитель
+	ready   chan struct{}      // Свободный вычислитель сообщает Run, что готов взять задачу
+	results chan resultRequest // Результаты вычислителей для отправки пачкой
+	policy  retry.Policy       // Повторы запросов к оркестратору
+	breaker *retry.Breaker     // Приостанавливает запросы, пока оркестратор недоступен
+
+	mu         sync.Mutex // Защищает Config, workers, inFlight, want, ctx и work
+	workers    map[int]*poolWorker
+	inFlight   map[int]InFlightTask // Задачи в работе по номеру вычислителя
+	want       int                  // Сколько вычислителей должно быть в пуле
+	nextWorker int
+	ctx        context.Context // Контекст из Run: после отмены вычислители не берут новые задачи
+	work       context.Context // Контекст вычислений: отменяется, когда время на доделывание задач истекло
+	wg         sync.WaitGroup
+}
 
-	agent := &Agent{
+// Создаёт агента с config.ComputingPower вычислителями
+func NewAgent(config env.Config) *Agent {
+	hostname, _ := os.Hostname()
+	return &Agent{
+		ID:     fmt.Sprintf("%s-%d", hostname, os.Getpid()),
 		ind:    1,
-		Tasks:  make([]chan models.Task, numWorkers),
-		IsFree: make([]bool, numWorkers),
-		Work:   make([]models.Task, numWorkers),
 		Config: config,
 		Client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: longPollWait + 15*time.Second, // Запрос задачи может ждать longPollWait
 		},
+		queue:    make(chan models.Task),
+		ready:    make(chan struct{}),
+		results:  make(chan resultRequest),
+		policy:   config.RetryPolicy(),
+		breaker:  retry.NewBreaker("Агент", config.BreakerFailures, time.Duration(config.BreakerCooldownMS)*time.Millisecond),
+		workers:  make(map[int]*poolWorker),
+		inFlight: make(map[int]InFlightTask),
+		want:     config.ComputingPower,
 	}
+}
 
-	for i := 0; i < numWorkers; i++ {
-		agent.Tasks[i] = make(chan models.Task, 1)
-		agent.IsFree[i] = true
+// Запускает вычислители и раздаёт им задачи. Задачи запрашиваются у оркестратора
+// пачкой по числу свободных вычислителей, результаты отправляются тоже пачками. После отмены ctx новые задачи не берутся,
+// а выданные доделываются в пределах DrainTimeoutSec
+func (a *Agent) Run(ctx context.Context) {
+	work, stopWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopWork()
+
+	a.mu.Lock()
+	log.Printf("Запуск агента %d с %d вычислителями", a.ind, a.want)
+	a.ctx, a.work = ctx, work
+	for len(a.workers) < a.want {
+		a.startWorker()
 	}
+	baseURL := "http://localhost" + a.Config.OrchestratorAddr
+	a.mu.Unlock()
+	go a.sendResults(work, baseURL) // Останавливается после вычислителей: stopWork в defer
 
-	return agent
+	a.dispatch(ctx, baseURL)
+	a.drain(stopWork)
 }
 
-// Запускает воркеры и распределяет задачи
-func (a *Agent) Run(stop <-chan struct{}) {
-	log.Printf("Запуск агента %d с %d вычислителями", a.ind, len(a.Tasks))
-
-	for i := 0; i < len(a.Tasks); i++ {
-		a.wg.Add(1)
-		go a.worker(i, a.Tasks[i], stop)
-	}
-
-	baseURL := "http://localhost" + a.Config.OrchestratorAddr
+// Раздаёт задачи свободным вычислителям, пока не отменён ctx. За задачами
+// идёт сразу для всех вычислителей, приславших ready
+func (a *Agent) dispatch(ctx context.Context, baseURL string) {
+	free := 0 // Вычислители, которые прислали ready и ждут задачу
 	for {
-		select {
-		case <-stop:
-			for i := 0; i < len(a.Tasks); i++ {
-				close(a.Tasks[i])
+		if free == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-a.ready: // Ждём свободный вычислитель
+				free++
 			}
-			a.wg.Wait()
-			return
-		default:
-			workerID := a.getFreeWorker() // Ищем свободный воркер
-			if workerID == -1 {
-				time.Sleep(100 * time.Millisecond)
-				continue
+		}
+	more:
+		for {
+			select {
+			case <-a.ready:
+				free++
+			default:
+				break more
 			}
+		}
 
-			task, err := a.getTask(baseURL) // Запрашиваем задачу у оркестратора
-			if err != nil {
-				if err.Error() == "no task available" {
-					time.Sleep(1 * time.Second)
-					continue
+		tasks, ok := a.nextTasks(ctx, baseURL, free)
+		if !ok {
+			return
+		}
+		log.Printf("[Агент %d] Получено задач: %d", a.ind, len(tasks))
+		for i, task := range tasks {
+			select {
+			case <-ctx.Done():
+				for _, task := range tasks[i:] { // Вычислители уже остановились, задачи достанутся другому агенту
+					a.handBack(baseURL, task)
 				}
-				log.Printf("[Агент %d] Ошибка при получении задачи: %v", a.ind, err)
-				continue
+				return
+			case a.queue <- task: // Вычислитель, приславший ready, уже ждёт задачу
+				free--
 			}
+		}
+	}
+}
 
-			log.Printf("[Агент %d] Получена задача %s для вычислителя %d", a.ind, task.ID, workerID)
-			a.IsFree[workerID] = false
-			a.Work[workerID] = *task
-			a.Tasks[workerID] <- *task
+// Ждёт, пока вычислители доделают задачи. По истечении DrainTimeoutSec
+// вычисления прерываются, и вычислители возвращают задачи оркестратору
+func (a *Agent) drain(stopWork context.CancelFunc) {
+	grace := time.Duration(a.config().DrainTimeoutSec) * time.Second
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+
+	log.Printf("[Агент %d] Остановка: новые задачи не берём, в работе %d, ждём до %s", a.ind, len(a.InFlight()), grace)
+	select {
+	case <-done:
+		log.Printf("[Агент %d] Остановлен, все задачи доделаны", a.ind)
+	case <-time.After(grace):
+		left := len(a.InFlight())
+		stopWork()
+		<-done
+		log.Printf("[Агент %d] Остановлен, не доделано задач: %d, они возвращены оркестратору", a.ind, left)
+	}
+}
+
+// Возвращает задачу оркестратору не посчитав, чтобы её выдали снова.
+// Контекст задачи к этому моменту обычно уже отменён, поэтому у запроса свой срок
+func (a *Agent) handBack(baseURL string, task models.Task) {
+	ctx, cancel := context.WithTimeout(context.Background(), handBackTimeout)
+	defer cancel()
+	body, _ := json.Marshal(models.Result{TaskID: task.ID, Released: true})
+	resp, err := a.post(ctx, baseURL+"/internal/task", body)
+	if err != nil {
+		log.Printf("[Агент %d] Не удалось вернуть задачу %s: %v", a.ind, task.ID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[Агент %d] Не удалось вернуть задачу %s: код ответа %d", a.ind, task.ID, resp.StatusCode)
+	}
+}
+
+// Сообщает оркестратору, какие операции агент считает, чтобы получать только
+// подходящие задачи. Повторяется по политике повторов
+func (a *Agent) register(ctx context.Context, baseURL string) error {
+	caps := a.config().AgentCapabilities()
+	body, err := json.Marshal(caps)
+	if err != nil {
+		return err
+	}
+	return a.policy.Do(ctx, func(ctx context.Context) error {
+		resp, err := a.post(ctx, baseURL+"/internal/agents", body)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &retry.StatusError{Code: resp.StatusCode}
+		}
+		log.Printf("[Агент %d] Зарегистрирован у оркестратора: операции %v", a.ind, caps.Operations)
+		return nil
+	})
+}
+
+// Запрашивает до max задач у оркестратора, пока не получит хотя бы одну; false - ctx отменён.
+// Запрос ждёт задачи на стороне оркестратора до longPollWait; при ошибках
+// (в том числе 503 от останавливающегося оркестратора) пауза растёт по политике повторов
+func (a *Agent) nextTasks(ctx context.Context, baseURL string, max int) ([]models.Task, bool) {
+	failures := 0
+	for {
+		tasks, err := a.getTasks(ctx, baseURL, max)
+		if err == nil {
+			return tasks, true
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+		if errors.Is(err, errPollTimeout) { // Оркестратор уже подождал за нас - спрашиваем снова
+			failures = 0
+			continue
+		}
+		if errors.Is(err, errNotRegistered) { // Первый запрос или оркестратор перезапустился
+			if err = a.register(ctx, baseURL); err == nil {
+				continue
+			}
 		}
+		log.Printf("[Агент %d] Ошибка при получении задач: %v", a.ind, err)
+		if retry.Sleep(ctx, a.policy.Backoff(failures)) != nil {
+			return nil, false
+		}
+		failures++
 	}
 }
 
-// Выполняет задачи в отдельной горутине
-func (a *Agent) worker(workerID int, taskChan <-chan models.Task, stop <-chan struct{}) {
+// Берёт задачи из общей очереди, пока не отменён ctx или вычислитель не убран из пула.
+// Задача считается в контексте work, чтобы остановка агента не прерывала её сразу
+func (a *Agent) worker(ctx, work context.Context, workerID int, baseURL string) {
 	defer a.wg.Done()
-	baseURL := "http://localhost" + a.Config.OrchestratorAddr
+	defer a.removeWorker(workerID)
 
 	for {
+		if a.retire(workerID) { // Убран из пула, пока считал задачу: новую не берём
+			log.Printf("[Агент %d] Вычислитель %d убран из пула", a.ind, workerID)
+			return
+		}
 		select {
-		case <-stop:
+		case <-ctx.Done():
 			return
-		case task := <-taskChan:
-			log.Printf("[Агент %d] Вычислитель %d: Принята задача %s: %+v", a.ind, workerID, task.ID, task)
-			result, err := a.processTask(&task, baseURL)
-			if err != nil {
-				log.Printf("[Агент %d] Вычислитель %d: Ошибка при обработке задачи %s: %v", a.ind, workerID, task.ID, err)
-				a.IsFree[workerID] = true
-				continue
+		case <-a.quitChan(workerID):
+			if a.retire(workerID) {
+				log.Printf("[Агент %d] Вычислитель %d убран из пула", a.ind, workerID)
+				return
 			}
-
-			log.Printf("[Агент %d] Вычислитель %d: Результат задачи %s готов к отправке: %f", a.ind, workerID, task.ID, result.Value)
-			for retries := 0; retries < 5; retries++ { // Пытаемся отправить результат до 5 раз с паузой
-				err = a.sendResult(baseURL, result)
-				if err != nil {
-					log.Printf("[Агент %d] Вычислитель %d: Ошибка при отправке результата для задачи %s: %v, попытка %d", a.ind, workerID, task.ID, err, retries+1)
-					time.Sleep(500 * time.Millisecond)
-					continue
-				}
-				log.Printf("[Агент %d] Вычислитель %d: Результат %s отправлен: %f", a.ind, workerID, task.ID, result.Value)
-				break
-			}
-			if err != nil {
-				log.Printf("[Агент %d] Вычислитель %d: Не удалось отправить результат для задачи %s после всех попыток: %v", a.ind, workerID, task.ID, err)
-				a.IsFree[workerID] = true // Освобождаем после 5 попыток чтобы не зависнуть на неудавшейся операции
-				continue
-			}
-
-			log.Printf("[Агент %d] Вычислитель %d: Задача %s выполнена: %f", a.ind, workerID, task.ID, result.Value)
-			a.IsFree[workerID] = true // Если отправили успешно, то освобождаем воркер
+			continue
+		case a.ready <- struct{}{}:
 		}
-	}
-}
 
-// Находит индекс свободного воркера
-func (a *Agent) getFreeWorker() int {
-	for i, free := range a.IsFree {
-		if free {
-			return i
+		// Run уже запрашивает задачу для этого вычислителя, выходить до её получения нельзя
+		select {
+		case <-ctx.Done():
+			return
+		case task := <-a.queue:
+			a.begin(workerID, task)
+			a.handleTask(work, workerID, task, baseURL)
+			a.finish(workerID)
 		}
 	}
-	return -1 // Если все заняты
 }
 
-// Запрашивает задачу у оркестратора и возвращает ее
-func (a *Agent) getTask(baseURL string) (*models.Task, error) {
-	resp, err := a.Client.Get(baseURL + "/internal/task")
+// Считает задачу и отправляет результат оркестратору. Срок задачи - аренда,
+// выданная оркестратором; прерванная задача возвращается в очередь
+func (a *Agent) handleTask(ctx context.Context, workerID int, task models.Task, baseURL string) {
+	ctx, cancel := taskContext(ctx, task)
+	defer cancel()
+
+	log.Printf("[Агент %d] Вычислитель %d: Принята задача %s: %+v", a.ind, workerID, task.ID, task)
+	result, err := a.processTask(ctx, &task, baseURL)
 	if err != nil {
-		return nil, err
+		log.Printf("[Агент %d] Вычислитель %d: Ошибка при обработке задачи %s: %v", a.ind, workerID, task.ID, err)
+		var ce *computeError
+		switch {
+		case ctx.Err() != nil: // Агент останавливается или аренда истекла
+			a.handBack(baseURL, task)
+		case errors.As(err, &ce): // Повтор не поможет - сообщаем оркестратору, что выражение не посчитать
+			if err := a.sendResult(ctx, &models.Result{TaskID: task.ID, Error: ce.msg}); err != nil {
+				log.Printf("[Агент %d] Вычислитель %d: Не удалось сообщить об ошибке задачи %s: %v", a.ind, workerID, task.ID, err)
+			}
+		}
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("no task available")
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	log.Printf("[Агент %d] Вычислитель %d: Результат задачи %s готов к отправке: %f", a.ind, workerID, task.ID, result.Value)
+	if err := a.sendResult(ctx, result); err != nil {
+		// Освобождаем вычислитель, чтобы не зависнуть на неудавшейся операции
+		log.Printf("[Агент %d] Вычислитель %d: Не удалось отправить результат для задачи %s: %v", a.ind, workerID, task.ID, err)
+		if ctx.Err() != nil {
+			a.handBack(baseURL, task)
+		}
+		return
 	}
+	log.Printf("[Агент %d] Вычислитель %d: Задача %s выполнена: %f", a.ind, workerID, task.ID, result.Value)
+}
 
-	var response struct {
-		Task models.Task `json:"task"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, err
+// Контекст задачи со сроком аренды, если оркестратор его выдал
+func taskContext(ctx context.Context, task models.Task) (context.Context, context.CancelFunc) {
+	if task.LeaseMS > 0 {
+		return context.WithTimeout(ctx, time.Duration(task.LeaseMS)*time.Millisecond)
 	}
-
-	return &response.Task, nil
+	return context.WithCancel(ctx)
 }
 
-// Вычисляет результат задачи и возвращает его
-func (a *Agent) processTask(task *models.Task, baseURL string) (*models.Result, error) {
+// Вычисляет результат задачи и возвращает его. Ожидание зависимостей и время
+// операции прерываются отменой ctx
+func (a *Agent) processTask(ctx context.Context, task *models.Task, baseURL string) (*models.Result, error) {
 	var arg1, arg2 float64
 	var err error
 
@@ -176,16 +316,9 @@ func (a *Agent) processTask(task *models.Task, baseURL string) (*models.Result,
 			return nil, fmt.Errorf("invalid arg1: %v", err)
 		}
 	} else {
-		for retries := 0; retries < 5; retries++ {
-			arg1, err = a.getTaskResult(baseURL, task.Arg1) // Если не число, то запрашиваем результат зависимости
-			if err == nil {
-				break
-			}
-			log.Printf("[Агент %d] Ожидание результата для %s: %v, попытка %d", a.ind, task.Arg1, err, retries+1)
-			time.Sleep(1 * time.Second)
-		}
+		arg1, err = a.getTaskResult(ctx, baseURL, task.Arg1) // Если не число, то запрашиваем результат зависимости
 		if err != nil {
-			return nil, fmt.Errorf("failed to get result for Arg1 %s after retries: %v", task.Arg1, err)
+			return nil, fmt.Errorf("failed to get result for Arg1 %s: %w", task.Arg1, err)
 		}
 	}
 
@@ -195,42 +328,31 @@ func (a *Agent) processTask(task *models.Task, baseURL string) (*models.Result,
 			return nil, fmt.Errorf("invalid arg2: %v", err)
 		}
 	} else {
-		for retries := 0; retries < 5; retries++ {
-			arg2, err = a.getTaskResult(baseURL, task.Arg2)
-			if err == nil {
-				break
-			}
-			log.Printf("[Агент %d] Ожидание результата для %s: %v, попытка %d", a.ind, task.Arg2, err, retries+1)
-			time.Sleep(1 * time.Second)
-		}
+		arg2, err = a.getTaskResult(ctx, baseURL, task.Arg2)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get result for Arg2 %s after retries: %v", task.Arg2, err)
+			return nil, fmt.Errorf("failed to get result for Arg2 %s: %w", task.Arg2, err)
 		}
 	}
 
+	op, ok := ops.Lookup(task.Operation)
+	if !ok {
+		return nil, &computeError{msg: fmt.Sprintf("unsupported operation: %s", task.Operation)}
+	}
 	var value float64
-	var operationTime int
-	switch task.Operation {
-	case "+":
-		value = arg1 + arg2
-		operationTime = a.Config.TimeAdditionMS
-	case "-":
-		value = arg1 - arg2
-		operationTime = a.Config.TimeSubtractionMS
-	case "*":
-		value = arg1 * arg2
-		operationTime = a.Config.TimeMultiplicationMS
-	case "/":
-		if arg2 == 0 {
-			return nil, fmt.Errorf("division by zero")
-		}
-		value = arg1 / arg2
-		operationTime = a.Config.TimeDivisionMS
-	default:
-		return nil, fmt.Errorf("unsupported operation: %s", task.Operation)
+	if task.Decimal {
+		value, err = evalDecimal(op, arg1, arg2)
+	} else {
+		value, err = op.Eval(arg1, arg2)
+	}
+	if err != nil {
+		return nil, &computeError{msg: err.Error()}
 	}
 
-	time.Sleep(time.Duration(operationTime) * time.Millisecond)
+	// Время операций могло измениться при перезагрузке конфигурации
+	operationTime := a.config().OperationCosts()[task.Operation]
+	if err := retry.Sleep(ctx, operationTime); err != nil {
+		return nil, err
+	}
 
 	return &models.Result{
 		TaskID: task.ID,
@@ -238,63 +360,83 @@ func (a *Agent) processTask(task *models.Task, baseURL string) (*models.Result,
 	}, nil
 }
 
-// Запрашивает результат зависимости (если текущая задача зависит от другой) у оркестратора
-func (a *Agent) getTaskResult(baseURL, taskID string) (float64, error) {
+// Запрашивает результат зависимости (если текущая задача зависит от другой) у оркестратора.
+// Пока результата нет или оркестратор недоступен, запрос повторяется по политике повторов
+func (a *Agent) getTaskResult(ctx context.Context, baseURL, taskID string) (float64, error) {
 	url := baseURL + "/internal/task/result/" + taskID
-	resp, err := a.Client.Get(url)
-	if err != nil {
-		return 0, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	var response struct {
-		Result float64 `json:"result"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return 0, err
-	}
-
-	return response.Result, nil
-}
-
-// Отправляет результат задачи оркестратору
-func (a *Agent) sendResult(baseURL string, result *models.Result) error {
-	body, err := json.Marshal(result)
-	if err != nil {
-		return err
-	}
-
-	maxRetries := 5
-	for retries := 0; retries < maxRetries; retries++ {
-		resp, err := a.Client.Post(baseURL+"/internal/task", "application/json", bytes.NewBuffer(body))
+	var result float64
+	err := a.policy.Do(ctx, func(ctx context.Context) error {
+		resp, err := a.get(ctx, url)
 		if err != nil {
-			log.Printf("[Агент %d] Ошибка отправки результата %s: %v, попытка %d", a.ind, result.TaskID, err, retries+1)
-			time.Sleep(500 * time.Millisecond)
-			continue
+			return err
 		}
 		defer resp.Body.Close()
 
 		switch resp.StatusCode {
 		case http.StatusOK:
-			log.Printf("[Агент %d] Результат %s отправлен: %f", a.ind, result.TaskID, result.Value)
-			return nil
-		case http.StatusInternalServerError: // 500
-			log.Printf("[Агент %d] Ошибка сервера 500 для задачи %s, попытка %d", a.ind, result.TaskID, retries+1)
-			time.Sleep(1 * time.Second)
-			continue
+		case http.StatusNotFound:
+			return errResultNotReady // Повторяем: результат ещё может появиться
+		case http.StatusGone:
+			return retry.Permanent(errExprFinished) // Выражение завершено, задачу считать незачем
 		default:
-			log.Printf("[Агент %d] Неожиданный код ответа %d для задачи %s", a.ind, resp.StatusCode, result.TaskID)
-			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			return &retry.StatusError{Code: resp.StatusCode}
 		}
+
+		var response struct {
+			Result float64 `json:"result"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+			return retry.Permanent(err)
+		}
+		result = response.Result
+		return nil
+	})
+	return result, err
+}
+
+// Выполняет GET-запрос к оркестратору от имени агента
+func (a *Agent) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
 	}
+	return a.do(req)
+}
 
-	log.Printf("[Агент %d] Не удалось отправить результат %s после %d попыток", a.ind, result.TaskID, maxRetries)
-	return fmt.Errorf("failed to send result after %d retries", maxRetries)
+// Выполняет POST-запрос с JSON-телом к оркестратору от имени агента
+func (a *Agent) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return a.do(req)
+}
+
+// Отправляет запрос через предохранитель: пока оркестратор недоступен,
+// запросы ждут, а не множат ошибки. Ошибки сети и 5xx считаются отказом оркестратора
+func (a *Agent) do(req *http.Request) (*http.Response, error) {
+	if err := a.breaker.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Agent-ID", a.ID)
+	resp, err := a.Client.Do(req)
+	switch {
+	case err != nil && req.Context().Err() == nil, err == nil && resp.StatusCode >= 500:
+		a.breaker.Failure()
+	case err == nil:
+		a.breaker.Success()
+	}
+	return resp, err
+}
+
+// Ошибка вычисления, которая не исчезнет при повторе (например, деление на ноль)
+type computeError struct {
+	msg string
+}
 
+func (e *computeError) Error() string {
+	return e.msg
 }
 
 func isNumeric(arg string) bool {
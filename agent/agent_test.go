@@ -1,12 +1,24 @@
 package agent
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/NieR8/myProject/internal/env"
 	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/ops"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestProcessTask(t *testing.T) {
-	agent := NewAgent()
+	agent := NewAgent(env.Default())
 	// Устанавливаем значения конфигурации для теста
 	agent.Config.TimeAdditionMS = 100
 	agent.Config.TimeSubtractionMS = 150
@@ -20,11 +32,20 @@ func TestProcessTask(t *testing.T) {
 	}{
 		{&models.Task{ID: "task-1", Arg1: "2", Arg2: "3", Operation: "+"}, 5, false},
 		{&models.Task{ID: "task-2", Arg1: "4", Arg2: "0", Operation: "/"}, 0, true},
+		{&models.Task{ID: "task-3", Arg1: "-7", Arg2: "2", Operation: "%"}, 1, false},
+		{&models.Task{ID: "task-4", Arg1: "-7", Arg2: "2", Operation: "//"}, -4, false},
+		{&models.Task{ID: "task-5", Arg1: "7.5", Arg2: "2", Operation: "//"}, 0, true},
+		{&models.Task{ID: "task-6", Arg1: "0.1", Arg2: "0.2", Operation: "+"}, 0.30000000000000004, false},
+		{&models.Task{ID: "task-7", Arg1: "0.1", Arg2: "0.2", Operation: "+", Decimal: true}, 0.3, false},
+		{&models.Task{ID: "task-8", Arg1: "1.1", Arg2: "1.1", Operation: "*", Decimal: true}, 1.21, false},
+		{&models.Task{ID: "task-9", Arg1: "0.3", Arg2: "0.1", Operation: "/", Decimal: true}, 3, false},
+		{&models.Task{ID: "task-10", Arg1: "1", Arg2: "0", Operation: "/", Decimal: true}, 0, true},
+		{&models.Task{ID: "task-11", Arg1: "-7", Arg2: "2", Operation: "%", Decimal: true}, 1, false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.task.ID, func(t *testing.T) {
-			result, err := agent.processTask(tt.task, "http://fake-url")
+			result, err := agent.processTask(context.Background(), tt.task, "http://fake-url")
 			if tt.wantErr {
 				if err == nil {
 					t.Errorf("processTask(%+v) expected error, got nil", tt.task)
@@ -40,3 +61,333 @@ func TestProcessTask(t *testing.T) {
 		})
 	}
 }
+
+// Оркестратор для тестов: раздаёт задачи a+b и принимает результаты
+type fakeOrchestrator struct {
+	mu       sync.Mutex
+	pending  []models.Task
+	results  map[string]float64
+	leased   int // Выдано, но ещё не посчитано
+	maxRun   int // Наибольшее число задач в работе одновременно
+	done     chan struct{}
+	total    int
+	leaseMS  int64
+	released []string             // Задачи, которые агент вернул не посчитав
+	gate     chan struct{}        // Пока не закрыт, результат зависимостей не готов
+	caps     *models.Capabilities // С чем агент зарегистрировался; пока nil, задачи не выдаются
+}
+
+func newFakeOrchestrator(n int) *fakeOrchestrator {
+	f := &fakeOrchestrator{results: make(map[string]float64), done: make(chan struct{}), total: n}
+	for i := 0; i < n; i++ {
+		f.pending = append(f.pending, models.Task{ID: fmt.Sprintf("task-%d", i), Arg1: fmt.Sprint(i), Arg2: "1", Operation: "+"})
+	}
+	return f
+}
+
+func (f *fakeOrchestrator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/internal/tasks":
+		limit, _ := strconv.Atoi(r.URL.Query().Get("max"))
+		f.mu.Lock()
+		if f.caps == nil {
+			f.mu.Unlock()
+			w.WriteHeader(http.StatusPreconditionRequired)
+			return
+		}
+		n := min(limit, len(f.pending))
+		tasks := append([]models.Task(nil), f.pending[:n]...)
+		f.pending = f.pending[n:]
+		f.leased += n
+		f.maxRun = max(f.maxRun, f.leased)
+		f.mu.Unlock()
+		if n == 0 {
+			time.Sleep(5 * time.Millisecond) // Как будто ждали задачу wait
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		for i := range tasks {
+			tasks[i].LeaseMS = f.leaseMS
+		}
+		json.NewEncoder(w).Encode(map[string][]models.Task{"tasks": tasks})
+	case "/internal/results":
+		var results []models.Result
+		json.NewDecoder(r.Body).Decode(&results)
+		acks := make([]models.ResultAck, len(results))
+		for i, result := range results {
+			f.accept(result)
+			acks[i] = models.ResultAck{TaskID: result.TaskID, Status: http.StatusOK}
+		}
+		json.NewEncoder(w).Encode(map[string][]models.ResultAck{"results": acks})
+	case "/internal/agents":
+		var caps models.Capabilities
+		json.NewDecoder(r.Body).Decode(&caps)
+		f.mu.Lock()
+		f.caps = &caps
+		f.mu.Unlock()
+	case "/internal/task": // Агент возвращает задачу
+		var result models.Result
+		json.NewDecoder(r.Body).Decode(&result)
+		f.accept(result)
+	default:
+		if !strings.HasPrefix(r.URL.Path, "/internal/task/result/") {
+			http.NotFound(w, r)
+			return
+		}
+		select {
+		case <-f.gate:
+			json.NewEncoder(w).Encode(map[string]float64{"result": 1})
+		default:
+			http.NotFound(w, r) // Зависимость ещё считается
+		}
+	}
+}
+
+func (f *fakeOrchestrator) accept(result models.Result) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if result.Released {
+		f.leased--
+		f.released = append(f.released, result.TaskID)
+		return
+	}
+	if _, dup := f.results[result.TaskID]; !dup {
+		f.leased--
+		f.results[result.TaskID] = result.Value
+		if len(f.results) == f.total {
+			close(f.done)
+		}
+	}
+}
+
+func TestAgentAgainstFakeOrchestrator(t *testing.T) {
+	const tasks, maxWorkers = 300, 8
+	fake := newFakeOrchestrator(tasks)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := env.Default()
+	config.ComputingPower = 4
+	config.TimeAdditionMS = 1
+	config.OrchestratorAddr = server.URL[strings.LastIndex(server.URL, ":"):]
+	agent := NewAgent(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished := make(chan struct{})
+	go func() {
+		agent.Run(ctx)
+		close(finished)
+	}()
+
+	// Меняем размер пула и читаем состояние, пока агент считает
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-fake.done:
+					return
+				default:
+				}
+				agent.Resize(1 + (g+i)%maxWorkers)
+				agent.InFlight()
+				agent.Workers()
+				time.Sleep(time.Millisecond)
+			}
+		}(g)
+	}
+
+	select {
+	case <-fake.done:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("посчитано %d из %d задач", len(fake.results), tasks)
+	}
+	wg.Wait()
+	cancel()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run не завершился после отмены контекста")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for i := 0; i < tasks; i++ {
+		if got := fake.results[fmt.Sprintf("task-%d", i)]; got != float64(i+1) {
+			t.Errorf("task-%d = %v, want %d", i, got, i+1)
+		}
+	}
+	if fake.caps == nil || !slices.Equal(fake.caps.Operations, ops.Symbols()) {
+		t.Errorf("агент зарегистрировался с %+v, want all registered operations", fake.caps)
+	}
+	if fake.maxRun > maxWorkers {
+		t.Errorf("одновременно выдано %d задач при пуле не больше %d", fake.maxRun, maxWorkers)
+	}
+	if n := agent.Workers(); n != 0 {
+		t.Errorf("Workers() = %d после остановки, want 0", n)
+	}
+}
+
+func TestResizeBeforeRun(t *testing.T) {
+	agent := NewAgent(env.Default())
+	agent.Resize(0)
+	if agent.want != 1 || agent.Workers() != 0 {
+		t.Errorf("want = %d, Workers() = %d", agent.want, agent.Workers())
+	}
+}
+
+func TestResizeWaitsForBusyWorkers(t *testing.T) {
+	fake := newFakeOrchestrator(2)
+	fake.gate = make(chan struct{})
+	for i := range fake.pending {
+		fake.pending[i].Arg1 = "task-dep" // Вычислители заняты, пока не открыт gate
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := env.Default()
+	config.ComputingPower = 2
+	config.OrchestratorAddr = server.URL[strings.LastIndex(server.URL, ":"):]
+	agent := NewAgent(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.Run(ctx)
+	eventually(t, "оба вычислителя взяли задачи", func() bool { return len(agent.InFlight()) == 2 })
+
+	agent.Resize(1)
+	if n := agent.Workers(); n != 2 {
+		t.Fatalf("Workers() = %d with two busy workers, want 2", n)
+	}
+	agent.Resize(2) // Уходящий вычислитель возвращается в пул, новый не запускается
+	agent.mu.Lock()
+	started := agent.nextWorker
+	agent.mu.Unlock()
+	if started != 2 {
+		t.Errorf("started %d workers, want 2", started)
+	}
+	agent.Resize(1)
+	if n := len(agent.InFlight()); n != 2 {
+		t.Fatalf("InFlight() = %d after shrinking, busy workers must keep their tasks", n)
+	}
+
+	close(fake.gate)
+	select {
+	case <-fake.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("задачи не посчитаны")
+	}
+	eventually(t, "пул уменьшился до 1", func() bool { return agent.Workers() == 1 })
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.released) != 0 || fake.results["task-0"] != 2 || fake.results["task-1"] != 2 {
+		t.Errorf("results = %v, released = %v, want both tasks computed", fake.results, fake.released)
+	}
+}
+
+// Ждёт, пока cond не станет истинным, до 5 секунд
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("не дождались: %s", what)
+}
+
+func TestLeaseExpiryInterruptsTask(t *testing.T) {
+	fake := newFakeOrchestrator(1)
+	fake.leaseMS = 50
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	config := env.Default()
+	config.ComputingPower = 1
+	config.TimeAdditionMS = 10000 // Без отмены вычисление заняло бы 10 секунд
+	config.OrchestratorAddr = server.URL[strings.LastIndex(server.URL, ":"):]
+	agent := NewAgent(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		fake.mu.Lock()
+		released := append([]string(nil), fake.released...)
+		fake.mu.Unlock()
+		if len(released) > 0 {
+			if released[0] != "task-0" {
+				t.Errorf("released = %v, want task-0", released)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("задача не возвращена после истечения аренды")
+}
+
+func TestSendResultRetriesEachResult(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	sent := make(map[string]int) // Сколько раз пришёл каждый результат
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var results []models.Result
+		json.NewDecoder(r.Body).Decode(&results)
+		acks := make([]models.ResultAck, len(results))
+		for i, result := range results {
+			sent[result.TaskID]++
+			status := http.StatusOK
+			switch {
+			case result.TaskID == "flaky" && sent["flaky"] < 3:
+				status = http.StatusServiceUnavailable
+			case result.TaskID == "unknown":
+				status = http.StatusNotFound
+			}
+			acks[i] = models.ResultAck{TaskID: result.TaskID, Status: status}
+		}
+		json.NewEncoder(w).Encode(map[string][]models.ResultAck{"results": acks})
+	}))
+	defer server.Close()
+
+	config := env.Default()
+	config.RetryInitialMS, config.RetryMaxMS = 1, 5
+	agent := NewAgent(config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agent.sendResults(ctx, server.URL)
+
+	errs := make(map[string]error)
+	var wg sync.WaitGroup
+	for _, id := range []string{"ok", "flaky", "unknown"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := agent.sendResult(ctx, &models.Result{TaskID: id, Value: 1})
+			mu.Lock()
+			errs[id] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if errs["ok"] != nil || errs["flaky"] != nil {
+		t.Errorf("ok: %v, flaky: %v, want both accepted", errs["ok"], errs["flaky"])
+	}
+	if errs["unknown"] == nil {
+		t.Error("unknown accepted, want 404 without retries")
+	}
+	if sent["ok"] != 1 || sent["flaky"] != 3 || sent["unknown"] != 1 {
+		t.Errorf("sent = %v, want ok:1 flaky:3 unknown:1", sent)
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/NieR8/myProject/internal/retry"
+	"github.com/NieR8/myProject/models"
+)
+
+// Сколько задач или результатов агент передаёт одним запросом; больше оркестратор не примет
+const maxBatch = 100
+
+// Результат, который вычислитель отдал на отправку общей пачкой
+type resultRequest struct {
+	result models.Result
+	done   chan error // С буфером: вычислитель может уже не ждать ответа
+}
+
+// Отправляет результаты вычислителей пачками, пока не отменён ctx. Пачку
+// составляют результаты, накопившиеся, пока шёл предыдущий запрос
+func (a *Agent) sendResults(ctx context.Context, baseURL string) {
+	for {
+		var batch []resultRequest
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-a.results:
+			batch = append(batch, req)
+		}
+	collect:
+		for len(batch) < maxBatch {
+			select {
+			case req := <-a.results:
+				batch = append(batch, req)
+			default:
+				break collect
+			}
+		}
+		a.postResults(ctx, baseURL, batch)
+	}
+}
+
+// Отправляет пачку одним запросом и сообщает каждому вычислителю итог его
+// результата: nil, код ответа оркестратора для этого результата или ошибку всего запроса
+func (a *Agent) postResults(ctx context.Context, baseURL string, batch []resultRequest) {
+	results := make([]models.Result, len(batch))
+	for i, req := range batch {
+		results[i] = req.result
+	}
+	acks, err := a.postBatch(ctx, baseURL, results)
+	for i, req := range batch {
+		switch {
+		case err != nil:
+			req.done <- err
+		case i >= len(acks) || acks[i].TaskID != req.result.TaskID:
+			req.done <- fmt.Errorf("no acknowledgement for task %s", req.result.TaskID)
+		case acks[i].Status != http.StatusOK:
+			req.done <- &retry.StatusError{Code: acks[i].Status}
+		default:
+			req.done <- nil
+		}
+	}
+}
+
+// POST /internal/results: возвращает подтверждения в порядке результатов
+func (a *Agent) postBatch(ctx context.Context, baseURL string, results []models.Result) ([]models.ResultAck, error) {
+	body, err := json.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.post(ctx, baseURL+"/internal/results", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &retry.StatusError{Code: resp.StatusCode}
+	}
+
+	var response struct {
+		Results []models.ResultAck `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+// Отправляет результат задачи оркестратору в общей пачке. Повторяется каждый
+// результат отдельно: при ошибках сети и 5xx всего запроса или только этого результата
+func (a *Agent) sendResult(ctx context.Context, result *models.Result) error {
+	return a.policy.Do(ctx, func(ctx context.Context) error {
+		req := resultRequest{result: *result, done: make(chan error, 1)}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case a.results <- req:
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-req.done:
+			if err != nil {
+				log.Printf("[Агент %d] Ошибка отправки результата %s: %v", a.ind, result.TaskID, err)
+				return err
+			}
+			log.Printf("[Агент %d] Результат %s отправлен: %f", a.ind, result.TaskID, result.Value)
+			return nil
+		}
+	})
+}
+
+// Запрашивает у оркестратора до max задач. Запрос ждёт задачи на стороне
+// оркестратора до longPollWait
+func (a *Agent) getTasks(ctx context.Context, baseURL string, max int) ([]models.Task, error) {
+	url := baseURL + "/internal/tasks?max=" + strconv.Itoa(min(max, maxBatch)) + "&wait=" + longPollWait.String()
+	resp, err := a.get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, errPollTimeout
+	}
+	if resp.StatusCode == http.StatusPreconditionRequired {
+		return nil, errNotRegistered
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &retry.StatusError{Code: resp.StatusCode}
+	}
+
+	var response struct {
+		Tasks []models.Task `json:"tasks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if len(response.Tasks) == 0 {
+		return nil, errPollTimeout
+	}
+	return response.Tasks, nil
+}
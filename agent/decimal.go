@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+
+	"github.com/NieR8/myProject/pkg/ops"
+)
+
+// Считает операцию десятичной задачи. Операнды берутся в кратчайшей
+// десятичной записи, + - * / считаются точно в рациональных числах, и только
+// результат округляется до float64: 0.1+0.2 даёт 0.3, а не 0.30000000000000004.
+// Остальные операции и бесконечные операнды считаются как обычно
+func evalDecimal(op ops.Operation, a, b float64) (float64, error) {
+	if math.IsInf(a, 0) || math.IsNaN(a) || math.IsInf(b, 0) || math.IsNaN(b) {
+		return op.Eval(a, b)
+	}
+	x, y := decimalRat(a), decimalRat(b)
+	var r big.Rat
+	switch op.Symbol {
+	case "+":
+		r.Add(x, y)
+	case "-":
+		r.Sub(x, y)
+	case "*":
+		r.Mul(x, y)
+	case "/":
+		if y.Sign() == 0 {
+			return 0, ops.ErrDivisionByZero
+		}
+		r.Quo(x, y)
+	default:
+		return op.Eval(a, b)
+	}
+	value, _ := r.Float64()
+	return value, nil
+}
+
+// Число в кратчайшей десятичной записи, которая читается в то же float64:
+// 0.1, а не 0.1000000000000000055511151231257827...
+func decimalRat(f float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return r
+}
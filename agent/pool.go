@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/NieR8/myProject/internal/env"
+	"github.com/NieR8/myProject/models"
+)
+
+// Задача, которую сейчас считает вычислитель
+type InFlightTask struct {
+	Worker  int         `json:"worker"`
+	Task    models.Task `json:"task"`
+	Started time.Time   `json:"started"`
+}
+
+// Вычислитель пула
+type poolWorker struct {
+	quit    chan struct{} // Закрывается, когда вычислитель убирают из пула; при возврате в пул заменяется
+	leaving bool          // Вычислитель дорабатывает задачу и выйдет
+}
+
+// Запускает новый вычислитель. Вызывается под a.mu после Run
+func (a *Agent) startWorker() {
+	id := a.nextWorker
+	a.nextWorker++
+	a.workers[id] = &poolWorker{quit: make(chan struct{})}
+	a.wg.Add(1)
+	go a.worker(a.ctx, a.work, id, "http://localhost"+a.Config.OrchestratorAddr)
+}
+
+// Канал, который закроется, когда вычислитель уберут из пула
+func (a *Agent) quitChan(id int) <-chan struct{} {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.workers[id].quit
+}
+
+// Убирает вычислитель из пула; false - пул успели снова расширить, и вычислитель остаётся
+func (a *Agent) retire(id int) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.workers[id].leaving {
+		return false
+	}
+	delete(a.workers, id)
+	return true
+}
+
+// Убирает вычислитель из пула, когда его горутина завершилась
+func (a *Agent) removeWorker(id int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.workers, id)
+	delete(a.inFlight, id)
+}
+
+// Отмечает, что вычислитель взял задачу
+func (a *Agent) begin(id int, task models.Task) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight[id] = InFlightTask{Worker: id, Task: task, Started: time.Now()}
+}
+
+// Отмечает, что вычислитель закончил задачу
+func (a *Agent) finish(id int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.inFlight, id)
+}
+
+// Меняет число вычислителей. Новые запускаются сразу, из лишних первыми
+// убираются свободные, занятые выходят, как только закончат текущую задачу
+func (a *Agent) Resize(n int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	old := a.want
+	a.want = n
+	if a.ctx == nil { // До Run вычислители запустит сам Run
+		return
+	}
+
+	var idle, busy, leaving []int
+	for id, w := range a.workers {
+		_, working := a.inFlight[id]
+		switch {
+		case w.leaving:
+			leaving = append(leaving, id)
+		case working:
+			busy = append(busy, id)
+		default:
+			idle = append(idle, id)
+		}
+	}
+	active := len(idle) + len(busy)
+	// Вычислители, которые ещё не вышли, возвращаем в пул, прежде чем запускать новые
+	for _, id := range leaving {
+		if active >= n {
+			break
+		}
+		a.workers[id].leaving = false
+		a.workers[id].quit = make(chan struct{})
+		active++
+	}
+	for ; active < n; active++ {
+		a.startWorker()
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(idle)))
+	sort.Sort(sort.Reverse(sort.IntSlice(busy)))
+	for _, id := range append(idle, busy...) {
+		if active <= n {
+			break
+		}
+		a.workers[id].leaving = true
+		close(a.workers[id].quit)
+		active--
+	}
+	if old != n {
+		log.Printf("[Агент %d] Пул вычислителей: %d -> %d", a.ind, old, n)
+	}
+}
+
+// Применяет новую конфигурацию без перезапуска: время операций действует
+// для следующих задач, размер пула меняется через Resize
+func (a *Agent) ApplyConfig(config env.Config) {
+	a.mu.Lock()
+	config.OrchestratorAddr = a.Config.OrchestratorAddr // Адрес меняется только перезапуском
+	a.Config = config
+	a.mu.Unlock()
+	a.Resize(config.ComputingPower)
+}
+
+// Текущая конфигурация агента
+func (a *Agent) config() env.Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.Config
+}
+
+// Текущее число вычислителей, включая дорабатывающие последнюю задачу
+func (a *Agent) Workers() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.workers)
+}
+
+// Задачи, которые сейчас считаются, по номеру вычислителя
+func (a *Agent) InFlight() []InFlightTask {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	tasks := make([]InFlightTask, 0, len(a.inFlight))
+	for _, t := range a.inFlight {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Worker < tasks[j].Worker })
+	return tasks
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ввод прерван по Ctrl-C: строка сбрасывается, REPL продолжает работу
+var errInterrupted = errors.New("interrupted")
+
+const maxHistory = 1000
+
+// Простой редактор строки: стрелки, Home/End, Backspace/Delete, Ctrl-A/E/U/K
+// и перебор истории стрелками вверх/вниз. Если ввод не терминал, строки
+// читаются целиком без редактирования
+type lineEditor struct {
+	in          io.Reader
+	out         io.Writer
+	reader      *bufio.Reader
+	history     []string
+	historyPath string
+}
+
+func newLineEditor(in io.Reader, out io.Writer, historyPath string) *lineEditor {
+	e := &lineEditor{in: in, out: out, reader: bufio.NewReader(in), historyPath: historyPath}
+	e.loadHistory()
+	return e
+}
+
+// Читает строку с приглашением prompt
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	if f, ok := e.in.(*os.File); ok {
+		if restore, err := makeRaw(f.Fd()); err == nil {
+			defer restore()
+			return e.readRaw(prompt)
+		}
+	}
+
+	fmt.Fprint(e.out, prompt)
+	line, err := e.reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (e *lineEditor) readRaw(prompt string) (string, error) {
+	var line []rune
+	cursor := 0
+	historyIndex := len(e.history)
+	saved := "" // Набранная строка, пока листаем историю
+
+	refresh := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - cursor; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(s string) {
+		line = []rune(s)
+		cursor = len(line)
+		refresh()
+	}
+	refresh()
+
+	for {
+		r, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if cursor < len(line) {
+				line = append(line[:cursor], line[cursor+1:]...)
+			}
+		case 127, 8: // Backspace
+			if cursor > 0 {
+				line = append(line[:cursor-1], line[cursor:]...)
+				cursor--
+			}
+		case 1: // Ctrl-A
+			cursor = 0
+		case 5: // Ctrl-E
+			cursor = len(line)
+		case 2: // Ctrl-B
+			if cursor > 0 {
+				cursor--
+			}
+		case 6: // Ctrl-F
+			if cursor < len(line) {
+				cursor++
+			}
+		case 21: // Ctrl-U
+			line = line[cursor:]
+			cursor = 0
+		case 11: // Ctrl-K
+			line = line[:cursor]
+		case 27: // Escape-последовательности стрелок и Home/End/Delete
+			seq := e.readEscape()
+			switch seq {
+			case "[A", "OA":
+				if historyIndex > 0 {
+					if historyIndex == len(e.history) {
+						saved = string(line)
+					}
+					historyIndex--
+					setLine(e.history[historyIndex])
+				}
+			case "[B", "OB":
+				if historyIndex < len(e.history) {
+					historyIndex++
+					if historyIndex == len(e.history) {
+						setLine(saved)
+					} else {
+						setLine(e.history[historyIndex])
+					}
+				}
+			case "[C", "OC":
+				if cursor < len(line) {
+					cursor++
+				}
+			case "[D", "OD":
+				if cursor > 0 {
+					cursor--
+				}
+			case "[H", "OH", "[1~":
+				cursor = 0
+			case "[F", "OF", "[4~":
+				cursor = len(line)
+			case "[3~":
+				if cursor < len(line) {
+					line = append(line[:cursor], line[cursor+1:]...)
+				}
+			}
+		default:
+			if r < 32 || r == utf8.RuneError {
+				continue
+			}
+			line = append(line[:cursor], append([]rune{r}, line[cursor:]...)...)
+			cursor++
+		}
+		refresh()
+	}
+}
+
+// Читает остаток escape-последовательности после ESC
+func (e *lineEditor) readEscape() string {
+	var seq strings.Builder
+	for {
+		b, err := e.reader.ReadByte()
+		if err != nil {
+			return seq.String()
+		}
+		seq.WriteByte(b)
+		// Последовательность заканчивается буквой или тильдой
+		if seq.Len() > 1 && (b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b == '~') {
+			return seq.String()
+		}
+		if seq.Len() > 8 {
+			return seq.String()
+		}
+	}
+}
+
+// Добавляет строку в историю и дописывает её в файл истории
+func (e *lineEditor) AddHistory(line string) {
+	if line == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+	if e.historyPath == "" {
+		return
+	}
+	f, err := os.OpenFile(e.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.historyPath == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
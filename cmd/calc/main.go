@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/client"
+)
+
+// Коды завершения
+const (
+	exitOK      = 0
+	exitFailed  = 1 // Выражение не посчиталось: невалидно или отменено
+	exitUsage   = 2
+	exitRequest = 3 // Оркестратор недоступен или вернул ошибку
+)
+
+const usage = `Использование: calc [флаги] <команда> [аргументы]
+
+Команды:
+  submit [--wait] [--priority N] [--no-cache] [--decimal] [-f файл] [выражение...]
+                      отправить выражения (без аргументов читаются из stdin, по одному на строку)
+  get <id>...         показать выражения
+  list                показать все выражения
+  watch <id>          следить за выражением до завершения
+  cancel <id>...      отменить выражения
+  agents              показать агентов
+  repl [--offline] [--history файл]
+                      интерактивный режим с переменными и историей
+
+Флаги:
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// Состояние CLI, общее для всех команд
+type cli struct {
+	client *client.Client
+	output string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("calc", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	addr := global.String("addr", envOr("CALC_ADDR", "http://localhost:8080"), "адрес оркестратора (CALC_ADDR)")
+	user := global.String("user", os.Getenv("CALC_USER"), "пользователь для заголовка X-User-ID (CALC_USER)")
+	output := global.String("o", "table", "формат вывода: table или json")
+	global.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		global.PrintDefaults()
+	}
+	if err := global.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "неизвестный формат вывода %q\n", *output)
+		return exitUsage
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return exitUsage
+	}
+
+	c := &cli{client: client.New(*addr), output: *output, stdin: stdin, stdout: stdout, stderr: stderr}
+	c.client.User = *user
+
+	cmd, rest := global.Arg(0), global.Args()[1:]
+	switch cmd {
+	case "submit":
+		return c.submit(rest)
+	case "get":
+		return c.get(rest)
+	case "list":
+		return c.list()
+	case "watch":
+		return c.watch(rest)
+	case "cancel":
+		return c.cancel(rest)
+	case "agents":
+		return c.agents()
+	case "repl":
+		return c.repl(rest)
+	default:
+		fmt.Fprintf(stderr, "неизвестная команда %q\n", cmd)
+		global.Usage()
+		return exitUsage
+	}
+}
+
+func (c *cli) submit(args []string) int {
+	fs := flag.NewFlagSet("submit", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	wait := fs.Bool("wait", false, "дождаться результата; код завершения 1, если выражение не посчиталось")
+	priority := fs.Int("priority", 0, "приоритет от 0 до 9")
+	noCache := fs.Bool("no-cache", false, "не брать результаты из кэша")
+	decimal := fs.Bool("decimal", false, "считать в десятичной арифметике")
+	file := fs.String("f", "", "файл с выражениями, по одному на строку (- для stdin)")
+	key := fs.String("idempotency-key", "", "ключ идемпотентности (только для одного выражения)")
+	interval := fs.Duration("interval", 500*time.Millisecond, "период опроса при --wait")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	expressions := fs.Args()
+	if *file != "" || len(expressions) == 0 {
+		fromFile, err := c.readExpressions(*file)
+		if err != nil {
+			fmt.Fprintln(c.stderr, err)
+			return exitUsage
+		}
+		expressions = append(expressions, fromFile...)
+	}
+	if len(expressions) == 0 {
+		fmt.Fprintln(c.stderr, "нет выражений для отправки")
+		return exitUsage
+	}
+	if *key != "" && len(expressions) > 1 {
+		fmt.Fprintln(c.stderr, "--idempotency-key можно использовать только с одним выражением")
+		return exitUsage
+	}
+
+	code := exitOK
+	var submitted []models.Expression
+	for _, expression := range expressions {
+		opts := client.SubmitOptions{Priority: *priority, NoCache: *noCache, Decimal: *decimal, IdempotencyKey: *key}
+		id, err := c.client.Submit(expression, opts)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: %v\n", expression, err)
+			code = worse(code, exitCodeFor(err))
+			continue
+		}
+		expr := models.Expression{Id: id, Name: expression, Status: models.StatusPending}
+		if *wait {
+			expr, err = c.waitFor(id, *interval, nil)
+			if err != nil {
+				fmt.Fprintf(c.stderr, "%s: %v\n", expression, err)
+				code = worse(code, exitRequest)
+				continue
+			}
+			if expr.Status != models.StatusCompleted {
+				code = worse(code, exitFailed)
+			}
+		}
+		submitted = append(submitted, expr)
+	}
+
+	if *wait {
+		c.printExpressions(submitted)
+	} else if c.output == "json" {
+		c.printJSON(submitted)
+	} else {
+		for _, expr := range submitted {
+			fmt.Fprintln(c.stdout, expr.Id)
+		}
+	}
+	return code
+}
+
+// Читает выражения из файла или stdin, пропуская пустые строки и комментарии
+func (c *cli) readExpressions(path string) ([]string, error) {
+	var r io.Reader = c.stdin
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var expressions []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		expressions = append(expressions, line)
+	}
+	return expressions, scanner.Err()
+}
+
+func (c *cli) get(args []string) int {
+	ids, code := parseIDs(args, c.stderr)
+	if code != exitOK {
+		return code
+	}
+	var expressions []models.Expression
+	for _, id := range ids {
+		expr, err := c.client.Get(id)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%d: %v\n", id, err)
+			code = worse(code, exitCodeFor(err))
+			continue
+		}
+		expressions = append(expressions, expr)
+	}
+	c.printExpressions(expressions)
+	return code
+}
+
+func (c *cli) list() int {
+	expressions, err := c.client.List()
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitCodeFor(err)
+	}
+	sortByID(expressions)
+	c.printExpressions(expressions)
+	return exitOK
+}
+
+func (c *cli) watch(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	interval := fs.Duration("interval", 500*time.Millisecond, "период опроса")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	ids, code := parseIDs(fs.Args(), c.stderr)
+	if code != exitOK {
+		return code
+	}
+	if len(ids) != 1 {
+		fmt.Fprintln(c.stderr, "watch принимает один ID")
+		return exitUsage
+	}
+
+	expr, err := c.waitFor(ids[0], *interval, func(expr models.Expression) {
+		if c.output == "json" {
+			c.printJSON(expr)
+			return
+		}
+		line := fmt.Sprintf("%s  #%d %s: %s", time.Now().Format("15:04:05"), expr.Id, expr.Name, expr.Status)
+		if expr.PredictedRemainingMS > 0 {
+			line += fmt.Sprintf(", осталось ~%dms", expr.PredictedRemainingMS)
+		}
+		fmt.Fprintln(c.stdout, line)
+	})
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitCodeFor(err)
+	}
+	if c.output == "table" && expr.Status == models.StatusCompleted {
+		fmt.Fprintln(c.stdout, formatResult(expr.Result))
+	}
+	if expr.Status != models.StatusCompleted {
+		return exitFailed
+	}
+	return exitOK
+}
+
+func (c *cli) cancel(args []string) int {
+	ids, code := parseIDs(args, c.stderr)
+	if code != exitOK {
+		return code
+	}
+	var expressions []models.Expression
+	for _, id := range ids {
+		expr, err := c.client.Cancel(id)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%d: %v\n", id, err)
+			code = worse(code, exitCodeFor(err))
+			continue
+		}
+		expressions = append(expressions, expr)
+	}
+	c.printExpressions(expressions)
+	return code
+}
+
+func (c *cli) agents() int {
+	agents, err := c.client.Agents()
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return exitCodeFor(err)
+	}
+	if c.output == "json" {
+		c.printJSON(agents)
+		return exitOK
+	}
+
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tНА СВЯЗИ\tЗАДАЧ В РАБОТЕ\tВЫПОЛНЕНО\tПОСЛЕДНИЙ ЗАПРОС")
+	for _, agent := range agents {
+		online := "нет"
+		if agent.Online {
+			online = "да"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", agent.ID, online, len(agent.InFlight), agent.Completed, agent.LastSeen.Format(time.RFC3339))
+	}
+	tw.Flush()
+	return exitOK
+}
+
+// Опрашивает выражение, пока оно не завершится. onChange вызывается при каждом изменении
+func (c *cli) waitFor(id int, interval time.Duration, onChange func(models.Expression)) (models.Expression, error) {
+	var last models.Expression
+	first := true
+	for {
+		expr, err := c.client.Get(id)
+		if err != nil {
+			return expr, err
+		}
+		if onChange != nil && (first || expr.Status != last.Status || expr.PredictedRemainingMS != last.PredictedRemainingMS) {
+			onChange(expr)
+		}
+		first, last = false, expr
+		if expr.Status.IsFinal() {
+			return expr, nil
+		}
+		time.Sleep(interval)
+	}
+}
+
+func (c *cli) printExpressions(expressions []models.Expression) {
+	if c.output == "json" {
+		c.printJSON(expressions)
+		return
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tСТАТУС\tРЕЗУЛЬТАТ\tВЫРАЖЕНИЕ")
+	for _, expr := range expressions {
+		result := expr.Error
+		if expr.Status == models.StatusCompleted {
+			result = formatResult(expr.Result)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", expr.Id, expr.Status, result, expr.Name)
+	}
+	tw.Flush()
+}
+
+func (c *cli) printJSON(v interface{}) {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func parseIDs(args []string, stderr io.Writer) ([]int, int) {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "укажите ID выражения")
+		return nil, exitUsage
+	}
+	ids := make([]int, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(stderr, "неверный ID %q\n", arg)
+			return nil, exitUsage
+		}
+		ids = append(ids, id)
+	}
+	return ids, exitOK
+}
+
+func formatResult(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func exitCodeFor(err error) int {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == 422 {
+		return exitFailed // Выражение отклонено как невалидное
+	}
+	return exitRequest
+}
+
+// Возвращает более серьёзный из двух кодов завершения
+func worse(a, b int) int {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func sortByID(expressions []models.Expression) {
+	sort.Slice(expressions, func(i, j int) bool {
+		return expressions[i].Id < expressions[j].Id
+	})
+}
+
+func envOr(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
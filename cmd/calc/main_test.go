@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/parser"
+)
+
+// Оркестратор для тестов команд: выражение при первом запросе считается,
+// при следующих завершено. Выражения 1 (2+2) и 2 (1/0) уже приняты
+type fakeOrchestrator struct {
+	mu          sync.Mutex
+	expressions map[int]*models.Expression
+	polls       map[int]int
+	submitted   []string
+	user        string // X-User-ID последнего запроса
+}
+
+func newFakeOrchestrator() *fakeOrchestrator {
+	f := &fakeOrchestrator{expressions: make(map[int]*models.Expression), polls: make(map[int]int)}
+	f.add("2+2")
+	f.add("1/0")
+	return f
+}
+
+// Добавляет выражение и возвращает его ID
+func (f *fakeOrchestrator) add(expression string) int {
+	id := len(f.expressions) + 1
+	f.expressions[id] = &models.Expression{Id: id, Name: expression, Status: models.StatusQueued}
+	return id
+}
+
+func (f *fakeOrchestrator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.user = r.Header.Get("X-User-ID")
+
+	if r.URL.Path == "/api/v1/agents" {
+		json.NewEncoder(w).Encode(map[string][]models.AgentInfo{"agents": {{ID: "a1", Online: true, InFlight: []string{"task-expr-1-0"}, Completed: 7}}})
+		return
+	}
+	if r.URL.Path == "/api/v1/calculate" {
+		var req struct {
+			Expression string `json:"expression"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case req.Expression == "boom":
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		case strings.HasSuffix(req.Expression, "+"):
+			http.Error(w, "Invalid expression", http.StatusUnprocessableEntity)
+			return
+		}
+		f.submitted = append(f.submitted, req.Expression)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"id": f.add(req.Expression)})
+		return
+	}
+
+	if r.URL.Path == "/api/v1/expressions" {
+		var list []*models.Expression
+		for id := len(f.expressions); id > 0; id-- { // Порядок не по ID: CLI сортирует сам
+			list = append(list, f.expressions[id])
+		}
+		json.NewEncoder(w).Encode(map[string][]*models.Expression{"expressions": list})
+		return
+	}
+
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/"))
+	expr, ok := f.expressions[id]
+	if !ok {
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodDelete:
+		expr.Status = models.StatusCancelled
+	case expr.Status.IsFinal():
+	case f.polls[id] == 0:
+		expr.Status = models.StatusRunning
+	default:
+		tree, _ := parseExpression(expr.Name)
+		if value, err := parser.Evaluate(tree); err != nil {
+			expr.Status, expr.Error = models.StatusFailed, err.Error()
+		} else {
+			expr.Status, expr.Result = models.StatusCompleted, value
+		}
+	}
+	f.polls[id]++
+	json.NewEncoder(w).Encode(map[string]*models.Expression{"expression": expr})
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "expressions.txt")
+	if err := os.WriteFile(file, []byte("# из файла\n3*3\n\n  # отступ\n10-4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		stdin     string
+		code      int
+		stdout    []string // Подстроки вывода
+		stderr    string
+		submitted []string // Что дошло до оркестратора, nil - не проверяется
+	}{
+		{name: "submit", args: []string{"submit", "2*3"}, code: exitOK, stdout: []string{"3\n"}, submitted: []string{"2*3"}},
+		{name: "submit from stdin", args: []string{"submit"}, stdin: "# комментарий\n\n1+1\n2+2\n", code: exitOK,
+			stdout: []string{"3\n4\n"}, submitted: []string{"1+1", "2+2"}},
+		{name: "submit from file", args: []string{"submit", "-f", file, "5-1"}, code: exitOK,
+			stdout: []string{"3\n4\n5\n"}, submitted: []string{"5-1", "3*3", "10-4"}},
+		{name: "submit from stdin dash", args: []string{"submit", "-f", "-"}, stdin: "#\n7/7\n", code: exitOK, submitted: []string{"7/7"}},
+		{name: "submit nothing", args: []string{"submit"}, stdin: "# только комментарий\n", code: exitUsage, stderr: "нет выражений"},
+		{name: "submit missing file", args: []string{"submit", "-f", filepath.Join(dir, "missing")}, code: exitUsage, stderr: "missing"},
+		{name: "submit wait", args: []string{"submit", "-wait", "-interval", "1ms", "2*3"}, code: exitOK, stdout: []string{"completed", "6"}},
+		{name: "submit wait failed", args: []string{"submit", "-wait", "-interval", "1ms", "2+2", "5/0"}, code: exitFailed,
+			stdout: []string{"completed", "failed", "division by zero"}},
+		{name: "submit invalid", args: []string{"submit", "2+", "1+1"}, code: exitFailed, stdout: []string{"3\n"}, stderr: "422"},
+		{name: "submit server error", args: []string{"submit", "2+", "boom"}, code: exitRequest, stderr: "500"},
+		{name: "idempotency key with many", args: []string{"submit", "-idempotency-key", "k", "1+1", "2+2"}, code: exitUsage, submitted: []string{}},
+		{name: "submit json", args: []string{"-o", "json", "submit", "-wait", "-interval", "1ms", "2*3"}, code: exitOK,
+			stdout: []string{`"status": "completed"`, `"result": 6`}},
+		{name: "get", args: []string{"get", "1", "2"}, code: exitOK, stdout: []string{"2+2", "1/0", "running"}},
+		{name: "get unknown", args: []string{"get", "1", "99"}, code: exitRequest, stdout: []string{"2+2"}, stderr: "99: 404"},
+		{name: "get bad id", args: []string{"get", "x"}, code: exitUsage, stderr: `неверный ID "x"`},
+		{name: "list", args: []string{"list"}, code: exitOK, stdout: []string{"2+2", "1/0"}},
+		{name: "list json", args: []string{"-o", "json", "list"}, code: exitOK, stdout: []string{`"id": 1,`, `"name": "1/0"`}},
+		{name: "watch", args: []string{"watch", "-interval", "1ms", "1"}, code: exitOK, stdout: []string{"#1 2+2: running", "#1 2+2: completed", "\n4\n"}},
+		{name: "watch failed", args: []string{"watch", "-interval", "1ms", "2"}, code: exitFailed, stdout: []string{"#2 1/0: failed"}},
+		{name: "watch json", args: []string{"-o", "json", "watch", "-interval", "1ms", "1"}, code: exitOK, stdout: []string{`"status": "running"`, `"status": "completed"`}},
+		{name: "watch unknown", args: []string{"watch", "99"}, code: exitRequest, stderr: "404"},
+		{name: "watch many", args: []string{"watch", "1", "2"}, code: exitUsage, stderr: "один ID"},
+		{name: "cancel", args: []string{"cancel", "1"}, code: exitOK, stdout: []string{"cancelled", "2+2"}},
+		{name: "cancel unknown", args: []string{"cancel", "2", "99"}, code: exitRequest, stdout: []string{"cancelled", "1/0"}, stderr: "99: 404"},
+		{name: "agents", args: []string{"agents"}, code: exitOK, stdout: []string{"a1", "да", "7"}},
+		{name: "agents json", args: []string{"-o", "json", "agents"}, code: exitOK, stdout: []string{`"id": "a1"`, `"completed": 7`}},
+		{name: "unknown format", args: []string{"-o", "xml", "list"}, code: exitUsage, stderr: `"xml"`},
+		{name: "unknown command", args: []string{"frobnicate"}, code: exitUsage, stderr: `"frobnicate"`},
+		{name: "no command", args: nil, code: exitUsage, stderr: "Использование"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeOrchestrator()
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			var stdout, stderr bytes.Buffer
+			code := run(append([]string{"-addr", server.URL}, tt.args...), strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("run(%v) = %d, want %d; stderr: %s", tt.args, code, tt.code, stderr.String())
+			}
+			for _, want := range tt.stdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("stdout does not contain %q:\n%s", want, stdout.String())
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("stderr does not contain %q:\n%s", tt.stderr, stderr.String())
+			}
+			if tt.submitted != nil && strings.Join(fake.submitted, ",") != strings.Join(tt.submitted, ",") {
+				t.Errorf("submitted %q, want %q", fake.submitted, tt.submitted)
+			}
+		})
+	}
+}
+
+func TestRunSendsUser(t *testing.T) {
+	fake := newFakeOrchestrator()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-addr", server.URL, "-user", "alice", "list"}, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+	if fake.user != "alice" {
+		t.Errorf("X-User-ID = %q, want alice", fake.user)
+	}
+}
+
+func TestRunUnreachableOrchestrator(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close() // Адрес больше никто не слушает
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-addr", server.URL, "submit", "-wait", "1+1"}, strings.NewReader(""), &stdout, &stderr); code != exitRequest {
+		t.Errorf("run() = %d, want %d; stderr: %s", code, exitRequest, stderr.String())
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/client"
+	"github.com/NieR8/myProject/pkg/parser"
+)
+
+const replHelp = `Команды REPL:
+  <выражение>        посчитать выражение, результат сохраняется в переменной _
+  <имя> = <выражение> посчитать и сохранить в переменную
+  :tree <выражение>  показать дерево операций (ParseRPN)
+  :tasks <выражение> показать задачи, которые сформирует BuildTasks
+  :vars              показать переменные
+  :offline           переключить локальное вычисление без оркестратора
+  :help              эта справка
+  :quit              выход (или Ctrl-D)
+`
+
+var (
+	identifierPattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+	assignmentPattern = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*=(.*)$`)
+)
+
+// Интерактивный сеанс: переменные и режим вычисления
+type repl struct {
+	cli     *cli
+	vars    map[string]float64
+	offline bool
+	poll    time.Duration
+}
+
+func (c *cli) repl(args []string) int {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	offline := fs.Bool("offline", false, "считать локально, не обращаясь к оркестратору")
+	history := fs.String("history", defaultHistoryPath(), "файл истории, пустая строка - не сохранять")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	log.SetOutput(io.Discard) // Журнал парсера мешает выводу REPL
+	r := &repl{cli: c, vars: make(map[string]float64), offline: *offline, poll: 100 * time.Millisecond}
+	editor := newLineEditor(c.stdin, c.stdout, *history)
+	fmt.Fprintln(c.stdout, "Калькулятор. :help - справка, :quit - выход")
+
+	for {
+		line, err := editor.ReadLine("calc> ")
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(c.stderr, err)
+				return exitRequest
+			}
+			return exitOK
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		editor.AddHistory(line)
+		if line == ":quit" || line == ":q" {
+			return exitOK
+		}
+		if err := r.execute(line); err != nil {
+			fmt.Fprintf(c.stdout, "ошибка: %v\n", err)
+		}
+	}
+}
+
+// Выполняет одну строку REPL
+func (r *repl) execute(line string) error {
+	out := r.cli.stdout
+	if strings.HasPrefix(line, ":") {
+		cmd, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch cmd {
+		case ":help":
+			fmt.Fprint(out, replHelp)
+		case ":vars":
+			names := make([]string, 0, len(r.vars))
+			for name := range r.vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				fmt.Fprintf(out, "%s = %s\n", name, formatResult(r.vars[name]))
+			}
+		case ":offline":
+			r.offline = !r.offline
+			fmt.Fprintf(out, "локальный режим: %v\n", r.offline)
+		case ":tree":
+			tree, err := r.parse(arg)
+			if err != nil {
+				return err
+			}
+			printTree(out, tree, "", true, true)
+		case ":tasks":
+			tree, err := r.parse(arg)
+			if err != nil {
+				return err
+			}
+			tasks, err := parser.BuildTasks("expr-repl", tree)
+			if err != nil {
+				return err
+			}
+			for _, task := range tasks {
+				fmt.Fprintf(out, "%s: %s %s %s\n", task.ID, task.Arg1, task.Operation, task.Arg2)
+			}
+		default:
+			return fmt.Errorf("неизвестная команда %s, см. :help", cmd)
+		}
+		return nil
+	}
+
+	name := "_"
+	expression := line
+	if m := assignmentPattern.FindStringSubmatch(line); m != nil {
+		name, expression = m[1], m[2]
+		if parser.IsKeyword(name) {
+			return fmt.Errorf("%s - зарезервированное имя, переменной быть не может", name)
+		}
+	}
+	value, err := r.evaluate(expression)
+	if err != nil {
+		return err
+	}
+	r.vars[name] = value
+	if name == "_" {
+		fmt.Fprintln(out, formatResult(value))
+	} else {
+		fmt.Fprintf(out, "%s = %s\n", name, formatResult(value))
+	}
+	return nil
+}
+
+// Считает выражение на оркестраторе, а если он недоступен - локально
+func (r *repl) evaluate(expression string) (float64, error) {
+	expanded, err := r.substitute(expression)
+	if err != nil {
+		return 0, err
+	}
+	if !r.offline {
+		value, err := r.evaluateRemote(expanded)
+		var apiErr *client.APIError
+		if err == nil || errors.As(err, &apiErr) || errors.Is(err, errExpressionFailed) {
+			return value, err
+		}
+		fmt.Fprintf(r.cli.stdout, "оркестратор недоступен (%v), переключаюсь на локальное вычисление\n", err)
+		r.offline = true
+	}
+
+	tree, err := parseExpression(expanded)
+	if err != nil {
+		return 0, err
+	}
+	return parser.Evaluate(tree)
+}
+
+var errExpressionFailed = errors.New("expression failed")
+
+func (r *repl) evaluateRemote(expression string) (float64, error) {
+	id, err := r.cli.client.Submit(expression, client.SubmitOptions{})
+	if err != nil {
+		return 0, err
+	}
+	expr, err := r.cli.waitFor(id, r.poll, nil)
+	if err != nil {
+		return 0, err
+	}
+	if expr.Status != models.StatusCompleted {
+		return 0, fmt.Errorf("%w: %s %s", errExpressionFailed, expr.Status, expr.Error)
+	}
+	return expr.Result, nil
+}
+
+// Подставляет значения переменных вместо их имён
+func (r *repl) substitute(expression string) (string, error) {
+	var missing string
+	expanded := identifierPattern.ReplaceAllStringFunc(expression, func(name string) string {
+		if parser.IsKeyword(name) {
+			return name // if и операции с буквенной записью не переменные
+		}
+		value, ok := r.vars[name]
+		if !ok {
+			missing = name
+			return name
+		}
+		text := strconv.FormatFloat(value, 'f', -1, 64)
+		if strings.HasPrefix(text, "-") {
+			return "(0-" + text[1:] + ")" // Парсер не принимает -0.5 после оператора
+		}
+		return text
+	})
+	if missing != "" {
+		return "", fmt.Errorf("неизвестная переменная %s", missing)
+	}
+	return expanded, nil
+}
+
+func (r *repl) parse(expression string) (*models.Node, error) {
+	expanded, err := r.substitute(expression)
+	if err != nil {
+		return nil, err
+	}
+	return parseExpression(expanded)
+}
+
+func parseExpression(expression string) (*models.Node, error) {
+	rpn, err := parser.InfixToRPN(expression)
+	if err != nil {
+		return nil, err
+	}
+	return parser.ParseRPN(rpn)
+}
+
+// Печатает дерево операций псевдографикой
+func printTree(out io.Writer, node *models.Node, prefix string, last, root bool) {
+	if node == nil {
+		return
+	}
+	branch, next := "", ""
+	if !root {
+		branch, next = "├── ", "│   "
+		if last {
+			branch, next = "└── ", "    "
+		}
+	}
+	value := node.Value
+	if num, err := strconv.ParseFloat(value, 64); err == nil {
+		value = formatResult(num)
+	}
+	fmt.Fprintf(out, "%s%s%s\n", prefix, branch, value)
+	if node.Cond != nil {
+		printTree(out, node.Cond, prefix+next, false, false)
+	}
+	if node.Left != nil || node.Right != nil {
+		printTree(out, node.Left, prefix+next, false, false)
+		printTree(out, node.Right, prefix+next, true, false)
+	}
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".calc_history")
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/NieR8/myProject/pkg/parser"
+)
+
+// Запускает REPL над строками input и возвращает его вывод
+func runREPL(t *testing.T, addr string, input ...string) string {
+	t.Helper()
+	args := []string{"repl", "-history", ""}
+	if addr == "" {
+		args = append(args, "-offline")
+	} else {
+		args = append([]string{"-addr", addr}, args...)
+	}
+	var stdout, stderr bytes.Buffer
+	if code := run(args, strings.NewReader(strings.Join(input, "\n")+"\n"), &stdout, &stderr); code != exitOK {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+	return stdout.String()
+}
+
+// Оркестратор для тестов REPL: считает присланные выражения сам и запоминает их
+type fakeCalculator struct {
+	mu        sync.Mutex
+	submitted []string
+}
+
+func (f *fakeCalculator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method == http.MethodPost {
+		var req struct {
+			Expression string `json:"expression"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.submitted = append(f.submitted, req.Expression)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"id": len(f.submitted)})
+		return
+	}
+	tree, err := parseExpression(f.submitted[len(f.submitted)-1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	value, _ := parser.Evaluate(tree)
+	// Статус прежним кодом 0: посчиталось
+	json.NewEncoder(w).Encode(map[string]map[string]interface{}{"expression": {"id": len(f.submitted), "status": 0, "result": value}})
+}
+
+func TestREPLVariables(t *testing.T) {
+	out := runREPL(t, "",
+		"x = 2+3",
+		"y = x*4",
+		"x + y",
+		"_ - 5",
+		"z + 1",
+		":vars",
+	)
+	for _, want := range []string{"x = 5\n", "y = 20\n", "25\n", "20\n", "ошибка: неизвестная переменная z", "_ = 20\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestREPLTreeAndTasks(t *testing.T) {
+	out := runREPL(t, "", "x = 2", ":tree (x+2)*y", ":tree (x+2)*3", ":tasks 1/(2+3)")
+	if !strings.Contains(out, "ошибка: неизвестная переменная y") {
+		t.Errorf(":tree with unknown variable did not fail:\n%s", out)
+	}
+	tree := "*\n├── +\n│   ├── 2\n│   └── 2\n└── 3\n"
+	if !strings.Contains(out, tree) {
+		t.Errorf("output does not contain tree\n%s\ngot:\n%s", tree, out)
+	}
+	if !strings.Contains(out, "task-expr-repl-0: 2") || !strings.Contains(out, " / task-expr-repl-0\n") {
+		t.Errorf(":tasks output lacks tasks of 1/(2+3):\n%s", out)
+	}
+}
+
+func TestREPLRemote(t *testing.T) {
+	fake := &fakeCalculator{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	out := runREPL(t, srv.URL, "x = 2*3", "x/4", "1/0")
+	if !strings.Contains(out, "x = 6\n") || !strings.Contains(out, "1.5\n") {
+		t.Errorf("output = %q, want x = 6 and 1.5", out)
+	}
+	if strings.Contains(out, "переключаюсь на локальное вычисление") {
+		t.Errorf("REPL fell back to offline with a working orchestrator:\n%s", out)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.submitted) != 3 || fake.submitted[1] != "6/4" {
+		t.Errorf("submitted %q, want x substituted", fake.submitted)
+	}
+}
+
+func TestREPLOfflineFallback(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close() // Оркестратор недоступен
+
+	out := runREPL(t, srv.URL, "1+2", "x = _*2", ":offline", ":offline")
+	if strings.Count(out, "оркестратор недоступен") != 1 {
+		t.Errorf("want one fallback notice:\n%s", out)
+	}
+	for _, want := range []string{"3\n", "x = 6\n", "локальный режим: false", "локальный режим: true"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestREPLHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	var stdout, stderr bytes.Buffer
+	input := "1+1\n1+1\n\n2*3\n:quit\n5/1\n"
+	if code := run([]string{"repl", "-offline", "-history", path}, strings.NewReader(input), &stdout, &stderr); code != exitOK {
+		t.Fatalf("run() = %d, stderr: %s", code, stderr.String())
+	}
+	if strings.Contains(stdout.String(), "5\n") {
+		t.Errorf("REPL kept reading after :quit:\n%s", stdout.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "1+1\n2*3\n:quit\n"; got != want {
+		t.Errorf("history file = %q, want %q", got, want)
+	}
+
+	editor := newLineEditor(strings.NewReader(""), io.Discard, path)
+	if got := strings.Join(editor.history, ","); got != "1+1,2*3,:quit" {
+		t.Errorf("loaded history = %q", got)
+	}
+}
+
+func TestLineEditorKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{"typing", "1+2\r", "1+2", nil},
+		{"backspace", "1+23\x7f\r", "1+2", nil},
+		{"left arrow inserts before cursor", "12\x1b[D+\r", "1+2", nil},
+		{"home and end", "+\x1b[H1\x1b[F2\r", "1+2", nil},
+		{"ctrl-a and ctrl-e", "+\x011\x052\r", "1+2", nil},
+		{"ctrl-b and ctrl-f", "13\x02+\x06*2\r", "1+3*2", nil},
+		{"ctrl-u kills to start", "9*1+2\x1b[D\x1b[D\x15\r", "+2", nil},
+		{"ctrl-k kills to end", "1+2*9\x1b[D\x1b[D\x0b\r", "1+2", nil},
+		{"delete", "1+x2\x1b[D\x1b[D\x1b[3~\r", "1+2", nil},
+		{"ctrl-d deletes under cursor", "1+x2\x02\x02\x04\r", "1+2", nil},
+		{"history up", "\x1b[A\r", "2*3", nil},
+		{"history up twice", "\x1b[A\x1b[A\r", "1+1", nil},
+		{"history down restores typed line", "7\x1b[A\x1b[A\x1b[B\x1b[B\r", "7", nil},
+		{"control characters ignored", "1\x07+2\r", "1+2", nil},
+		{"ctrl-c", "1+2\x03", "", errInterrupted},
+		{"ctrl-d on empty line", "\x04", "", io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			e := &lineEditor{out: &out, reader: bufio.NewReader(strings.NewReader(tt.input)), history: []string{"1+1", "2*3"}}
+			line, err := e.readRaw("calc> ")
+			if line != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("readRaw(%q) = %q, %v, want %q, %v", tt.input, line, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestREPLConditionalOffline(t *testing.T) {
+	out := runREPL(t, "",
+		"x = 150",
+		"if(x > 100, x*0.9, x)",
+		"y = x - 200",
+		"y < 0 ? -y : y",
+		"if = 1",
+	)
+	for _, want := range []string{"x = 150\n", "135\n", "y = -50\n", "50\n", "ошибка: if - зарезервированное имя"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "неизвестная переменная") {
+		t.Errorf("if was substituted as a variable:\n%s", out)
+	}
+}
+
+func TestREPLConditionalOnline(t *testing.T) {
+	fake := &fakeCalculator{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	out := runREPL(t, srv.URL, "x = 150", "if(x > 100, x*0.9, x)")
+	if !strings.Contains(out, "135\n") {
+		t.Errorf("output = %q, want 135", out)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.submitted) != 2 || strings.TrimSpace(fake.submitted[1]) != "if(150 > 100, 150*0.9, 150)" {
+		t.Errorf("submitted %q, want if kept and x substituted", fake.submitted)
+	}
+}
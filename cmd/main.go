@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/NieR8/myProject/agent"
 	"github.com/NieR8/myProject/internal/env"
 	"github.com/NieR8/myProject/orchestrator"
@@ -9,22 +12,51 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
-func main() {
-	// Загружаем конфигурацию
-	config := env.LoadConfig()
+// Как часто проверять, не изменился ли файл конфигурации
+const configWatchInterval = 2 * time.Second
 
-	// Канал для остановки агента
-	stop := make(chan struct{})
+func main() {
+	// Загружаем конфигурацию: файл, переменные окружения, флаги
+	config, printConfig, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if errors.Is(err, env.ErrUsage) {
+		os.Exit(2) // Сообщение и справку уже напечатал flag
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка конфигурации:\n%v\n", err)
+		os.Exit(2)
+	}
+	if printConfig {
+		config.Write(os.Stdout)
+		return
+	}
 
-	// Контекст для остановки оркестратора
+	// Контекст для остановки оркестратора и агента
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	orch := orchestrator.NewOrchestrator(config)
+	agt := agent.NewAgent(config)
+
+	// Перезагружаем конфигурацию по SIGHUP и при изменении файла
+	reloader := env.NewReloader(config, func() (env.Config, error) {
+		config, _, err := loadConfig(os.Args[1:])
+		return config, err
+	})
+	reloader.OnReload(orch.ApplyConfig)
+	reloader.OnReload(agt.ApplyConfig)
+	orch.Reloader = reloader
+	go reloader.Watch(ctx, configWatchInterval)
+
 	// Запускаем оркестратор
-	orch := orchestrator.NewOrchestrator(config.OrchestratorAddr)
+	orchDone := make(chan struct{})
 	go func() {
+		defer close(orchDone)
 		log.Printf("Оркестратор запущен на %s", config.OrchestratorAddr)
 		if err := orch.Run(ctx); err != nil {
 			log.Printf("Ошибка оркестратора: %v", err)
@@ -32,20 +64,44 @@ func main() {
 	}()
 
 	// Запускаем агента
-	agt := agent.NewAgent()
+	agentDone := make(chan struct{})
 	go func() {
+		defer close(agentDone)
 		log.Printf("Агент запущен с %d вычислителями", config.ComputingPower)
-		agt.Run(stop)
+		agt.Run(ctx)
 	}()
 
 	// Ожидаем сигнал остановки
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloader.Reload("SIGHUP")
+	}
 
-	// Останавливаем приложение
-	log.Println("Получен сигнал остановки")
-	close(stop)
+	// Останавливаем приложение: агент доделывает задачи, оркестратор ждёт их
+	// результаты и сохраняет состояние. Повторный сигнал завершает сразу
+	log.Printf("Получен сигнал остановки, ждём выданные задачи до %d с", config.DrainTimeoutSec)
 	cancel()
+	go func() {
+		for sig := range sigChan {
+			if sig != syscall.SIGHUP {
+				log.Println("Повторный сигнал, завершаем без ожидания")
+				os.Exit(1)
+			}
+		}
+	}()
+	<-agentDone
+	<-orchDone
 	log.Println("Приложение остановлено")
 }
+
+// Собирает конфигурацию из аргументов командной строки; второе значение - флаг -print-config
+func loadConfig(args []string) (env.Config, bool, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	printConfig := fs.Bool("print-config", false, "вывести итоговую конфигурацию и выйти")
+	config, err := env.Load(fs, args)
+	return config, *printConfig, err
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/NieR8/myProject/internal/store"
+	"github.com/NieR8/myProject/models"
+)
+
+// POST /internal/agents - агент сообщает, что умеет: операции и ограничения
+// на одновременные задачи. После этого ему выдаются только подходящие задачи
+func HandleAgents(st *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		agent := agentID(r)
+		if agent == "" {
+			http.Error(w, "Missing X-Agent-ID header", http.StatusBadRequest)
+			return
+		}
+		var caps models.Capabilities
+		if err := json.NewDecoder(r.Body).Decode(&caps); err != nil {
+			http.Error(w, "Invalid request", http.StatusUnprocessableEntity)
+			return
+		}
+		if len(caps.Operations) == 0 {
+			http.Error(w, "Agent must support at least one operation", http.StatusUnprocessableEntity)
+			return
+		}
+		for op, limit := range caps.MaxConcurrency {
+			if limit < 1 || !caps.Supports(op) {
+				http.Error(w, "Invalid max_concurrency for operation "+op, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		st.RegisterAgent(agent, caps)
+		log.Printf("Агент %s зарегистрирован: операции %v, ограничения %v", agent, caps.Operations, caps.MaxConcurrency)
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NieR8/myProject/internal/store"
+	"github.com/NieR8/myProject/models"
+)
+
+// Хранилище с выражением (1+2)*(3+4) и зарегистрированным агентом a1
+func newTestStore() *store.Store {
+	st := store.NewStore()
+	st.AddExpression(models.Expression{Name: "(1+2)*(3+4)", Status: models.StatusQueued, Id: 1,
+		Node: &models.Node{Value: "*",
+			Left:  &models.Node{Value: "+", Left: &models.Node{Value: "1"}, Right: &models.Node{Value: "2"}},
+			Right: &models.Node{Value: "+", Left: &models.Node{Value: "3"}, Right: &models.Node{Value: "4"}}}})
+	st.AddTasks(1, []models.Task{
+		{ID: "task-expr-1-2", Arg1: "task-expr-1-0", Arg2: "task-expr-1-1", Operation: "*"},
+		{ID: "task-expr-1-1", Arg1: "3", Arg2: "4", Operation: "+"},
+		{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"},
+	})
+	st.RegisterAgent("a1", models.Capabilities{Operations: []string{"+", "*"}})
+	return st
+}
+
+func request(handler http.HandlerFunc, method, url, agent, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if agent != "" {
+		r.Header.Set("X-Agent-ID", agent)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestHandleTasksLeasesBatch(t *testing.T) {
+	st := newTestStore()
+
+	if w := request(HandleTasks(st), http.MethodGet, "/internal/tasks?max=5", "stranger", ""); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("unregistered agent: %d, want 428", w.Code)
+	}
+	if w := request(HandleTasks(st), http.MethodGet, "/internal/tasks?max=0", "a1", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("max=0: %d, want 400", w.Code)
+	}
+
+	w := request(HandleTasks(st), http.MethodGet, "/internal/tasks?max=5", "a1", "")
+	var resp struct {
+		Tasks []models.Task `json:"tasks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /internal/tasks: %d %s", w.Code, w.Body)
+	}
+	if len(resp.Tasks) != 2 || resp.Tasks[0].Operation != "+" || resp.Tasks[1].Operation != "+" {
+		t.Errorf("leased %+v, want both ready additions", resp.Tasks)
+	}
+	if w := request(HandleTasks(st), http.MethodGet, "/internal/tasks?max=5", "a1", ""); w.Code != http.StatusNoContent {
+		t.Errorf("no ready tasks: %d, want 204", w.Code)
+	}
+}
+
+func TestHandleResultsAcksEachResult(t *testing.T) {
+	st := newTestStore()
+	request(HandleTasks(st), http.MethodGet, "/internal/tasks?max=5", "a1", "")
+
+	body := `[
+		{"task_id": "task-expr-1-0", "value": 3},
+		{"task_id": "task-expr-9-0", "value": 1},
+		{"task_id": "", "value": 1},
+		{"task_id": "task-expr-1-1", "value": 7}
+	]`
+	w := request(HandleResults(st), http.MethodPost, "/internal/results", "a1", body)
+	var resp struct {
+		Results []models.ResultAck `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("POST /internal/results: %d %s", w.Code, w.Body)
+	}
+	want := []int{http.StatusOK, http.StatusNotFound, http.StatusUnprocessableEntity, http.StatusOK}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("acks = %+v, want %d", resp.Results, len(want))
+	}
+	for i, ack := range resp.Results {
+		if ack.Status != want[i] {
+			t.Errorf("ack %d = %+v, want status %d", i, ack, want[i])
+		}
+	}
+
+	// Ошибочные результаты не помешали принять остальные: умножение готово
+	w = request(HandleTasks(st), http.MethodGet, "/internal/tasks?max=5", "a1", "")
+	if !strings.Contains(w.Body.String(), "task-expr-1-2") {
+		t.Errorf("multiplication is not ready after the batch: %d %s", w.Code, w.Body)
+	}
+	if w := request(HandleResults(st), http.MethodPost, "/internal/results", "a1", "{"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("malformed batch: %d, want 422", w.Code)
+	}
+}
+
+func TestLateResultOfFinishedExpression(t *testing.T) {
+	st := newTestStore()
+	request(HandleTasks(st), http.MethodGet, "/internal/tasks?max=5", "a1", "")
+	request(HandleResults(st), http.MethodPost, "/internal/results", "a1", `[{"task_id": "task-expr-1-0", "value": 3}]`)
+	st.CancelExpression(1, "user")
+
+	// Задачи отменённого выражения удалены, но опоздавший результат принимается
+	w := request(HandleResults(st), http.MethodPost, "/internal/results", "a1", `[{"task_id": "task-expr-1-1", "value": 7}]`)
+	if !strings.Contains(w.Body.String(), `"status":200`) {
+		t.Errorf("late result: %d %s, want ack 200", w.Code, w.Body)
+	}
+	if w := request(HandleTaskResult(st), http.MethodGet, "/internal/task/result/task-expr-1-0", "a1", ""); w.Code != http.StatusGone {
+		t.Errorf("dependency of a cancelled expression: %d, want 410", w.Code)
+	}
+	if w := request(HandleTaskResult(st), http.MethodGet, "/internal/task/result/task-expr-9-0", "a1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown task: %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/NieR8/myProject/internal/store"
+	"github.com/NieR8/myProject/models"
+)
+
+// Наибольшее число задач или результатов в одном пакетном запросе
+const MaxBatch = 100
+
+// GET /internal/tasks?max=N[&wait=30s] - выдаёт агенту до N готовых задач,
+// которые он умеет считать, одним запросом. Если задач нет (или не появились
+// за wait), отвечает 204. Агенту, который не зарегистрировался (например,
+// после перезапуска оркестратора), отвечает 428
+func HandleTasks(st *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		max, err := parseMax(r.URL.Query().Get("max"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		wait, err := parseWait(r.URL.Query().Get("wait"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		agent := agentID(r)
+		if !st.AgentRegistered(agent) {
+			http.Error(w, "Agent not registered: POST /internal/agents first", http.StatusPreconditionRequired)
+			return
+		}
+		var tasks []models.Task
+		if wait == 0 {
+			tasks = st.LeaseTasks(agent, max)
+		} else {
+			ctx, cancel := context.WithTimeout(r.Context(), wait)
+			tasks = st.WaitLeaseTasks(ctx, agent, max)
+			cancel()
+		}
+		st.TouchAgent(agent)
+		if len(tasks) == 0 {
+			if r.Context().Err() != nil { // Сервер останавливается или агент ушёл
+				http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Context().Err() != nil { // Задачи нашлись, но отдать их уже некому
+			for _, task := range tasks {
+				st.ReleaseTask(task.ID, agent)
+			}
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Tasks []models.Task `json:"tasks"`
+		}{Tasks: tasks})
+	}
+}
+
+// POST /internal/results - принимает массив результатов и подтверждает
+// каждый отдельно: ошибка одного результата не отменяет остальные
+func HandleResults(st *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var results []models.Result
+		if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+			log.Printf("Ошибка декодирования результатов: %v", err)
+			http.Error(w, "Invalid request", http.StatusUnprocessableEntity)
+			return
+		}
+		if len(results) > MaxBatch {
+			http.Error(w, fmt.Sprintf("Too many results: at most %d per request", MaxBatch), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		agent := agentID(r)
+		st.TouchAgent(agent)
+		acks := make([]models.ResultAck, len(results))
+		for i, result := range results {
+			code, msg := applyResult(st, agent, result)
+			acks[i] = models.ResultAck{TaskID: result.TaskID, Status: code, Error: msg}
+		}
+		log.Printf("Получено результатов пачкой: %d", len(results))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Results []models.ResultAck `json:"results"`
+		}{Results: acks})
+	}
+}
+
+// Разбирает параметр max: число задач от 1 до MaxBatch, по умолчанию 1
+func parseMax(raw string) (int, error) {
+	if raw == "" {
+		return 1, nil
+	}
+	max, err := strconv.Atoi(raw)
+	if err != nil || max < 1 {
+		return 0, fmt.Errorf("invalid max %q: expected a positive integer", raw)
+	}
+	return min(max, MaxBatch), nil
+}
@@ -1,14 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/NieR8/myProject/internal/store"
 	"github.com/NieR8/myProject/models"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
+// Наибольшее время ожидания задачи в GET /internal/task?wait=
+const MaxWait = time.Minute
+
 func HandleTask(st *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -32,15 +38,45 @@ func HandleTaskResult(st *store.Store) http.HandlerFunc {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		st.TouchAgent(agentID(r))
 		handleGetTaskResult(w, r, st) // Возвращает результат задачи агенту
 	}
 }
 
-// Выдаёт следующую готовую задачу агенту
+// Выдаёт следующую готовую задачу агенту. С параметром wait (например,
+// wait=30s) запрос ждёт задачу до этого срока и отвечает 204, если она
+// не появилась. Если сервер останавливается, ожидающие получают 503
 func handleGetTask(w http.ResponseWriter, r *http.Request, st *store.Store) {
-	task, exists := st.GetPendingTask()
+	wait, err := parseWait(r.URL.Query().Get("wait"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var task models.Task
+	var exists bool
+	if wait == 0 {
+		task, exists = st.GetPendingTask(agentID(r))
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		task, exists = st.WaitPendingTask(ctx, agentID(r))
+		cancel()
+	}
 	if !exists {
-		http.Error(w, "No task available", http.StatusNotFound)
+		st.TouchAgent(agentID(r))
+		switch {
+		case wait == 0:
+			http.Error(w, "No task available", http.StatusNotFound)
+		case r.Context().Err() != nil: // Сервер останавливается или агент ушёл
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+	if r.Context().Err() != nil { // Задача нашлась, но отдать её уже некому
+		st.ReleaseTask(task.ID, agentID(r))
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -50,6 +86,18 @@ func handleGetTask(w http.ResponseWriter, r *http.Request, st *store.Store) {
 	}{Task: task})
 }
 
+// Разбирает параметр wait: длительность вида 30s или 500ms, не больше MaxWait
+func parseWait(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(raw)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("invalid wait %q: expected a duration like 30s", raw)
+	}
+	return min(wait, MaxWait), nil
+}
+
 // Принимает результат выполненной задачи
 func handlePostTask(w http.ResponseWriter, r *http.Request, st *store.Store) {
 	var result models.Result
@@ -60,20 +108,36 @@ func handlePostTask(w http.ResponseWriter, r *http.Request, st *store.Store) {
 	}
 
 	log.Printf("Получен результат для задачи %s: %f", result.TaskID, result.Value)
+	if code, msg := applyResult(st, agentID(r), result); code != http.StatusOK {
+		http.Error(w, msg, code)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Применяет результат агента (или возврат задачи). Возвращает HTTP-код
+// и текст ошибки; общий для POST /internal/task и POST /internal/results
+func applyResult(st *store.Store, agent string, result models.Result) (int, string) {
 	if result.TaskID == "" {
 		log.Println("Отсутствует TaskID в результате")
-		http.Error(w, "Missing task ID", http.StatusUnprocessableEntity)
-		return
+		return http.StatusUnprocessableEntity, "Missing task ID"
+	}
+
+	if result.Released {
+		if !st.ReleaseTask(result.TaskID, agent) {
+			return http.StatusNotFound, "Task not found"
+		}
+		return http.StatusOK, ""
 	}
 
 	if !st.UpdateTask(result) {
 		log.Printf("Задача %s не найдена при обновлении", result.TaskID)
-		http.Error(w, "Task not found", http.StatusNotFound)
-		return
+		return http.StatusNotFound, "Task not found"
 	}
 
+	st.AgentFinishedTask(agent, result.TaskID)
 	log.Printf("Результат задачи %s успешно принят: %f", result.TaskID, result.Value)
-	w.WriteHeader(http.StatusOK)
+	return http.StatusOK, ""
 }
 
 func handleGetTaskResult(w http.ResponseWriter, r *http.Request, st *store.Store) {
@@ -84,6 +148,12 @@ func handleGetTaskResult(w http.ResponseWriter, r *http.Request, st *store.Store
 		return
 	}
 
+	if st.Retired(taskID) {
+		log.Printf("Выражение задачи %s уже завершено", taskID)
+		http.Error(w, "Expression already finished", http.StatusGone) // Агенту незачем ждать результат
+		return
+	}
+
 	st.Mu.Lock()
 	defer st.Mu.Unlock()
 
@@ -106,3 +176,8 @@ func handleGetTaskResult(w http.ResponseWriter, r *http.Request, st *store.Store
 		Result float64 `json:"result"`
 	}{Result: task.Result})
 }
+
+// Возвращает идентификатор агента из заголовка X-Agent-ID
+func agentID(r *http.Request) string {
+	return r.Header.Get("X-Agent-ID")
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Кэш результатов вычислений с вытеснением давно неиспользуемых записей (LRU)
+// и ограниченным временем жизни записи
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	order    *list.List // Спереди - недавно использованные
+
+	hits, misses, evictions uint64
+}
+
+type entry struct {
+	key     string
+	value   float64
+	expires time.Time
+}
+
+// Статистика обращений к кэшу
+type Stats struct {
+	Size      int     `json:"size"`
+	Capacity  int     `json:"capacity"`
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+// Создаёт кэш на capacity записей. При capacity <= 0 кэш отключён, при ttl <= 0 записи не устаревают
+func New(capacity int, ttl time.Duration) *Cache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &Cache{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Возвращает значение по ключу и учитывает обращение в статистике. Nil-кэш
+// всегда промахивается
+func (c *Cache) Get(key string) (float64, bool) {
+	value, ok := c.Peek(key)
+	c.Count(ok)
+	return value, ok
+}
+
+// Возвращает значение по ключу, не трогая счётчики попаданий и промахов:
+// так ищутся поддеревья выражения, а обращение к кэшу одно на выражение,
+// см. Count. Найденная запись считается недавно использованной
+func (c *Cache) Peek(key string) (float64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return 0, false
+	}
+	e := elem.Value.(*entry)
+	if c.ttl > 0 && time.Now().After(e.expires) {
+		c.remove(elem)
+		return 0, false
+	}
+	c.order.MoveToFront(elem)
+	return e.value, true
+}
+
+// Учитывает в статистике одно обращение: попадание или промах
+func (c *Cache) Count(hit bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// Сохраняет значение, вытесняя самую старую запись при переполнении
+func (c *Cache) Put(key string, value float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&entry{key: key, value: value, expires: expires})
+	if c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := Stats{
+		Size:      c.order.Len(),
+		Capacity:  c.capacity,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+	if total := c.hits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits) / float64(total)
+	}
+	return stats
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*entry).key)
+}
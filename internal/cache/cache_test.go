@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheLRU(t *testing.T) {
+	c := New(2, time.Minute)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a") // "b" становится самой старой записью
+	c.Put("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("Get(b) hit, want evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("Get(a) = %v, %v, want 1, true", v, ok)
+	}
+	stats := c.Stats()
+	if stats.Size != 2 || stats.Hits != 2 || stats.Misses != 1 || stats.Evictions != 1 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	c := New(2, time.Millisecond)
+	c.Put("a", 1)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("Get(a) hit after TTL")
+	}
+	if _, ok := New(0, 0).Get("a"); ok {
+		t.Errorf("disabled cache hit")
+	}
+}
+
+func TestCachePeekDoesNotCount(t *testing.T) {
+	c := New(2, time.Minute)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	if v, ok := c.Peek("a"); !ok || v != 1 { // "a" становится недавно использованной
+		t.Errorf("Peek(a) = %v, %v, want 1, true", v, ok)
+	}
+	if _, ok := c.Peek("x"); ok {
+		t.Errorf("Peek(x) hit")
+	}
+	c.Put("c", 3)
+	if _, ok := c.Peek("b"); ok {
+		t.Errorf("Peek(b) hit, want evicted")
+	}
+	c.Count(true)
+	if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Errorf("Stats() = %+v, want only the counted hit", stats)
+	}
+	New(0, 0).Count(false) // Отключённый кэш ничего не считает
+}
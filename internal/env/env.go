@@ -1,46 +1,158 @@
 package env
 
 import (
-	"os"
+	"fmt"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NieR8/myProject/internal/retry"
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/ops"
 )
 
-// Cодержит конфигурацию приложения, загруженную из переменных окружения среды
+// Cодержит конфигурацию приложения. Значения собираются по слоям: значения
+// по умолчанию, файл конфигурации, переменные окружения, флаги командной строки
 type Config struct {
 	ComputingPower       int
 	TimeAdditionMS       int
 	TimeSubtractionMS    int
 	TimeMultiplicationMS int
 	TimeDivisionMS       int
+	TimeModuloMS         int // Время остатка от деления %
+	TimeFloorDivisionMS  int // Время целочисленного деления //
 	OrchestratorAddr     string
+	FoldConstants        bool // Считать операции над двумя числами сразу в оркестраторе, без агентов
+
+	RateLimitIPPerMin   int // Запросов в минуту с одного IP, 0 - без ограничения
+	RateLimitIPBurst    int
+	RateLimitUserPerMin int // Запросов в минуту от одного пользователя (X-User-ID), 0 - без ограничения
+	RateLimitUserBurst  int
+	DailyQuota          int    // Выражений в сутки на владельца, 0 - без ограничения
+	MaxExpressionLength int    // Максимальная длина выражения в символах
+	MaxTasks            int    // Максимальное число задач, которые может породить одно выражение
+	IdempotencyTTLSec   int    // Сколько секунд помнить ключи Idempotency-Key
+	CacheSize           int    // Сколько результатов поддеревьев хранить в кэше, 0 - кэш отключён
+	CacheTTLSec         int    // Время жизни записи кэша в секундах
+	LegacyStatusCodes   bool   // Отдавать статусы выражений прежними числами 0-4 вместо строк
+	DrainTimeoutSec     int    // Сколько секунд при остановке ждать задачи, уже выданные агентам
+	LeaseTimeoutSec     int    // Сколько секунд агент может считать задачу, потом она выдаётся снова
+	ExprTimeoutSec      int    // Сколько секунд выражение может считаться, потом оно завершается статусом timed_out; 0 - без ограничения
+	StateFile           string // Куда сохранять выражения при остановке и откуда читать при запуске, пусто - не сохранять
+
+	RetryInitialMS     int // Пауза агента после первой неудачной попытки связаться с оркестратором
+	RetryMaxMS         int // Пауза между попытками растёт вдвое, но не больше этого значения
+	RetryMaxElapsedSec int // Сколько всего секунд повторять один запрос
+	RetryJitterPercent int // Случайный разброс паузы в процентах, чтобы агенты не повторяли хором
+	BreakerFailures    int // После стольких неудач подряд агент приостанавливает запросы
+	BreakerCooldownMS  int // На сколько приостанавливаются запросы, потом пробный запрос
+
+	AgentOperations     string // Операции, которые считает агент, через запятую; пусто - все из реестра ops
+	AgentMaxConcurrency string // Ограничения на одновременные задачи по операциям, например "/=1,*=2"
+
+	File    string            // Файл конфигурации, из которого прочитаны значения
+	sources map[string]string // Откуда взято каждое значение, для --print-config
 }
 
-// Загружает конфигурацию из переменных окружения
-func LoadConfig() Config {
+// Возвращает конфигурацию по умолчанию
+func Default() Config {
 	return Config{
-		ComputingPower:       getEnvInt("COMPUTING_POWER", 3),
-		TimeAdditionMS:       getEnvInt("TIME_ADDITION_MS", 200),
-		TimeSubtractionMS:    getEnvInt("TIME_SUBTRACTION_MS", 150),
-		TimeMultiplicationMS: getEnvInt("TIME_MULTIPLICATIONS_MS", 100),
-		TimeDivisionMS:       getEnvInt("TIME_DIVISIONS_MS", 250),
-		OrchestratorAddr:     getEnvString("ORCHESTRATOR_ADDR", ":8080"),
+		ComputingPower:       3,
+		TimeAdditionMS:       200,
+		TimeSubtractionMS:    150,
+		TimeMultiplicationMS: 100,
+		TimeDivisionMS:       250,
+		TimeModuloMS:         250,
+		TimeFloorDivisionMS:  250,
+		OrchestratorAddr:     ":8080",
+		FoldConstants:        true,
+
+		RateLimitIPPerMin:   120,
+		RateLimitIPBurst:    30,
+		RateLimitUserPerMin: 60,
+		RateLimitUserBurst:  20,
+		DailyQuota:          10000,
+		MaxExpressionLength: 1000,
+		MaxTasks:            500,
+		IdempotencyTTLSec:   86400,
+		CacheSize:           10000,
+		CacheTTLSec:         3600,
+		DrainTimeoutSec:     30,
+		LeaseTimeoutSec:     60,
+		ExprTimeoutSec:      300,
+
+		RetryInitialMS:     100,
+		RetryMaxMS:         5000,
+		RetryMaxElapsedSec: 30,
+		RetryJitterPercent: 20,
+		BreakerFailures:    5,
+		BreakerCooldownMS:  5000,
+	}
+}
+
+// Политика повторов запросов агента к оркестратору
+func (c Config) RetryPolicy() retry.Policy {
+	return retry.Policy{
+		InitialInterval: time.Duration(c.RetryInitialMS) * time.Millisecond,
+		MaxInterval:     time.Duration(c.RetryMaxMS) * time.Millisecond,
+		Multiplier:      2,
+		Jitter:          float64(c.RetryJitterPercent) / 100,
+		MaxElapsed:      time.Duration(c.RetryMaxElapsedSec) * time.Second,
 	}
 }
 
-// Читает переменную окружения и возвращает ее с дефолтным значением
-func getEnvInt(key string, defaultValue int) int {
-	if value, exists := os.LookupEnv(key); exists {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// Возможности агента, которые он сообщает оркестратору. Формат значений уже проверен в Validate
+func (c Config) AgentCapabilities() models.Capabilities {
+	caps, _ := c.agentCapabilities()
+	return caps
+}
+
+func (c Config) agentCapabilities() (models.Capabilities, error) {
+	caps := models.Capabilities{Decimal: true} // Агент считает десятичные задачи точно, см. agent.evalDecimal
+	if strings.TrimSpace(c.AgentOperations) == "" {
+		caps.Operations = ops.Symbols()
+	} else {
+		for _, op := range strings.Split(c.AgentOperations, ",") {
+			op = strings.TrimSpace(op)
+			if !ops.IsOperator(op) {
+				return caps, fmt.Errorf("agent_operations: unknown operation %q", op)
+			}
+			if !caps.Supports(op) {
+				caps.Operations = append(caps.Operations, op)
+			}
+		}
+	}
+	if strings.TrimSpace(c.AgentMaxConcurrency) == "" {
+		return caps, nil
+	}
+	caps.MaxConcurrency = make(map[string]int)
+	for _, item := range strings.Split(c.AgentMaxConcurrency, ",") {
+		op, raw, ok := strings.Cut(item, "=")
+		op = strings.TrimSpace(op)
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if !ok || err != nil || n < 1 {
+			return caps, fmt.Errorf("agent_max_concurrency: expected operation=limit with a positive limit, got %q", strings.TrimSpace(item))
+		}
+		if !caps.Supports(op) {
+			return caps, fmt.Errorf("agent_max_concurrency: operation %q is not in agent_operations", op)
 		}
+		caps.MaxConcurrency[op] = n
 	}
-	return defaultValue
+	return caps, nil
 }
 
-// Читает переменную окружения и также возвращает ее с дефолтным значением
-func getEnvString(key string, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+// Возвращает время выполнения каждой операции из реестра ops: для четырёх
+// арифметических и целочисленных - из конфигурации, для остальных - время по умолчанию из реестра
+func (c Config) OperationCosts() map[string]time.Duration {
+	costs := make(map[string]time.Duration)
+	for _, op := range ops.All() {
+		costs[op.Symbol] = op.DefaultCost
 	}
-	return defaultValue
+	costs["+"] = time.Duration(c.TimeAdditionMS) * time.Millisecond
+	costs["-"] = time.Duration(c.TimeSubtractionMS) * time.Millisecond
+	costs["*"] = time.Duration(c.TimeMultiplicationMS) * time.Millisecond
+	costs["/"] = time.Duration(c.TimeDivisionMS) * time.Millisecond
+	costs["%"] = time.Duration(c.TimeModuloMS) * time.Millisecond
+	costs["//"] = time.Duration(c.TimeFloorDivisionMS) * time.Millisecond
+	return costs
 }
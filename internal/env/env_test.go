@@ -0,0 +1,101 @@
+package env
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calc.toml")
+	content := "# агенты\ncomputing_power = 5\ntime_addition_ms = 10 # быстрее\norchestrator_addr = \":9090\"\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TIME_ADDITION_MS", "20")
+	t.Setenv("TIME_DIVISIONS_MS", "30") // Прежнее имя переменной тоже читается
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c, err := Load(fs, []string{"-config", path, "-orchestrator-addr", ":7070", "-fold-constants=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ComputingPower != 5 || c.TimeAdditionMS != 20 || c.TimeDivisionMS != 30 || c.OrchestratorAddr != ":7070" || c.FoldConstants {
+		t.Errorf("Load() = %+v", c)
+	}
+	if c.TimeSubtractionMS != Default().TimeSubtractionMS {
+		t.Errorf("time_subtraction_ms = %d, want default", c.TimeSubtractionMS)
+	}
+
+	var out strings.Builder
+	c.Write(&out)
+	for _, line := range []string{
+		"computing_power = 5 # file " + path,
+		"time_addition_ms = 20 # env TIME_ADDITION_MS",
+		`orchestrator_addr = ":7070" # flag -orchestrator-addr`,
+		"cache_size = 10000 # default",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Write() has no line %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestLoadRejectsBadValues(t *testing.T) {
+	t.Setenv("COMPUTING_POWER", "abc")
+	t.Setenv("CACHE_TTL_SEC", "0")
+	t.Setenv("AGENT_MAX_CONCURRENCY", "/=0")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := Load(fs, []string{"-orchestrator-addr", "localhost"})
+	if err == nil {
+		t.Fatal("Load() expected error")
+	}
+	for _, want := range []string{"COMPUTING_POWER", "cache_ttl_sec", "orchestrator_addr", "agent_max_concurrency"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	t.Setenv("AGENT_MAX_CONCURRENCY", "")
+	t.Setenv("AGENT_OPERATIONS", "+,^")
+	t.Setenv("COMPUTING_POWER", "0")
+	if _, err := Load(flag.NewFlagSet("test", flag.ContinueOnError), nil); err == nil || !strings.Contains(err.Error(), "computing_power must be at least 1") {
+		t.Errorf("COMPUTING_POWER=0: err = %v", err)
+	} else if !strings.Contains(err.Error(), `unknown operation "^"`) {
+		t.Errorf("AGENT_OPERATIONS=+,^: err = %v", err)
+	}
+}
+
+func TestReloaderKeepsConfigOnError(t *testing.T) {
+	next, fail := Default(), false
+	next.ComputingPower = 7
+	r := NewReloader(Default(), func() (Config, error) {
+		if fail {
+			return Config{}, errors.New("bad file")
+		}
+		return next, nil
+	})
+	var got []int
+	r.OnReload(func(c Config) { got = append(got, c.ComputingPower) })
+
+	if err := r.Reload("test"); err != nil {
+		t.Fatal(err)
+	}
+	fail = true
+	if err := r.Reload("test"); err == nil {
+		t.Fatal("Reload() expected error")
+	}
+	if r.Current().ComputingPower != 7 || len(got) != 1 || got[0] != 7 {
+		t.Errorf("Current() = %d, listeners got %v", r.Current().ComputingPower, got)
+	}
+	if s := r.Stats(); s.Succeeded != 1 || s.Failed != 1 || s.LastError != "bad file" {
+		t.Errorf("Stats() = %+v", s)
+	}
+	if d := Diff(Default(), next); len(d) != 1 || d[0] != "computing_power" {
+		t.Errorf("Diff() = %v", d)
+	}
+}
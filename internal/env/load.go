@@ -0,0 +1,311 @@
+package env
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Переменная окружения с путём к файлу конфигурации, если не задан флаг -config
+const ConfigFileEnv = "CONFIG_FILE"
+
+// Ошибка разбора флагов; flag уже напечатал сообщение и справку
+var ErrUsage = errors.New("invalid command line")
+
+// Одна настройка: ключ в файле, переменная окружения и флаг
+type setting struct {
+	key    string   // Ключ в файле; имя флага - тот же ключ через дефис
+	env    string   // Переменная окружения
+	legacy []string // Прежние имена переменной окружения
+	usage  string
+	value  settingValue
+}
+
+type settingValue interface {
+	set(raw string) error
+	String() string
+}
+
+type intValue struct{ p *int }
+
+func (v intValue) set(raw string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", raw)
+	}
+	*v.p = n
+	return nil
+}
+
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) set(raw string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(raw))
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", raw)
+	}
+	*v.p = b
+	return nil
+}
+
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+
+type stringValue struct{ p *string }
+
+func (v stringValue) set(raw string) error {
+	*v.p = raw
+	return nil
+}
+
+func (v stringValue) String() string { return strconv.Quote(*v.p) }
+
+// Все настройки конфигурации в порядке вывода --print-config
+func (c *Config) settings() []setting {
+	return []setting{
+		{"computing_power", "COMPUTING_POWER", nil, "число вычислителей агента", intValue{&c.ComputingPower}},
+		{"time_addition_ms", "TIME_ADDITION_MS", nil, "время сложения, мс", intValue{&c.TimeAdditionMS}},
+		{"time_subtraction_ms", "TIME_SUBTRACTION_MS", nil, "время вычитания, мс", intValue{&c.TimeSubtractionMS}},
+		{"time_multiplication_ms", "TIME_MULTIPLICATION_MS", []string{"TIME_MULTIPLICATIONS_MS"}, "время умножения, мс", intValue{&c.TimeMultiplicationMS}},
+		{"time_division_ms", "TIME_DIVISION_MS", []string{"TIME_DIVISIONS_MS"}, "время деления, мс", intValue{&c.TimeDivisionMS}},
+		{"time_modulo_ms", "TIME_MODULO_MS", nil, "время остатка от деления %, мс", intValue{&c.TimeModuloMS}},
+		{"time_floor_division_ms", "TIME_FLOOR_DIVISION_MS", nil, "время целочисленного деления //, мс", intValue{&c.TimeFloorDivisionMS}},
+		{"orchestrator_addr", "ORCHESTRATOR_ADDR", nil, "адрес оркестратора, host:port", stringValue{&c.OrchestratorAddr}},
+		{"fold_constants", "FOLD_CONSTANTS", nil, "сворачивать операции над числами в оркестраторе", boolValue{&c.FoldConstants}},
+		{"rate_limit_ip_per_min", "RATE_LIMIT_IP_PER_MIN", nil, "запросов в минуту с одного IP, 0 - без ограничения", intValue{&c.RateLimitIPPerMin}},
+		{"rate_limit_ip_burst", "RATE_LIMIT_IP_BURST", nil, "всплеск запросов с одного IP", intValue{&c.RateLimitIPBurst}},
+		{"rate_limit_user_per_min", "RATE_LIMIT_USER_PER_MIN", nil, "запросов в минуту от пользователя, 0 - без ограничения", intValue{&c.RateLimitUserPerMin}},
+		{"rate_limit_user_burst", "RATE_LIMIT_USER_BURST", nil, "всплеск запросов от пользователя", intValue{&c.RateLimitUserBurst}},
+		{"daily_quota", "DAILY_QUOTA", nil, "выражений в сутки на владельца, 0 - без ограничения", intValue{&c.DailyQuota}},
+		{"max_expression_length", "MAX_EXPRESSION_LENGTH", nil, "максимальная длина выражения, 0 - без ограничения", intValue{&c.MaxExpressionLength}},
+		{"max_tasks", "MAX_TASKS", nil, "максимум задач из одного выражения, 0 - без ограничения", intValue{&c.MaxTasks}},
+		{"idempotency_ttl_sec", "IDEMPOTENCY_TTL_SEC", nil, "сколько секунд помнить ключи Idempotency-Key", intValue{&c.IdempotencyTTLSec}},
+		{"cache_size", "CACHE_SIZE", nil, "размер кэша результатов, 0 - кэш отключён", intValue{&c.CacheSize}},
+		{"cache_ttl_sec", "CACHE_TTL_SEC", nil, "время жизни записи кэша, с", intValue{&c.CacheTTLSec}},
+		{"legacy_status_codes", "LEGACY_STATUS_CODES", nil, "отдавать статусы прежними числами 0-4", boolValue{&c.LegacyStatusCodes}},
+		{"drain_timeout_sec", "DRAIN_TIMEOUT_SEC", nil, "сколько секунд при остановке ждать выданные агентам задачи", intValue{&c.DrainTimeoutSec}},
+		{"lease_timeout_sec", "LEASE_TIMEOUT_SEC", nil, "сколько секунд агент может считать задачу, потом она выдаётся снова", intValue{&c.LeaseTimeoutSec}},
+		{"expression_timeout_sec", "EXPRESSION_TIMEOUT_SEC", nil, "сколько секунд выражение может считаться, 0 - без ограничения", intValue{&c.ExprTimeoutSec}},
+		{"retry_initial_ms", "RETRY_INITIAL_MS", nil, "пауза агента после первой неудачной попытки, мс", intValue{&c.RetryInitialMS}},
+		{"retry_max_ms", "RETRY_MAX_MS", nil, "наибольшая пауза между попытками, мс", intValue{&c.RetryMaxMS}},
+		{"retry_max_elapsed_sec", "RETRY_MAX_ELAPSED_SEC", nil, "сколько всего секунд повторять один запрос", intValue{&c.RetryMaxElapsedSec}},
+		{"retry_jitter_percent", "RETRY_JITTER_PERCENT", nil, "случайный разброс паузы, %", intValue{&c.RetryJitterPercent}},
+		{"breaker_failures", "BREAKER_FAILURES", nil, "неудач подряд, после которых агент приостанавливает запросы", intValue{&c.BreakerFailures}},
+		{"breaker_cooldown_ms", "BREAKER_COOLDOWN_MS", nil, "на сколько приостанавливаются запросы, мс", intValue{&c.BreakerCooldownMS}},
+		{"agent_operations", "AGENT_OPERATIONS", nil, "операции, которые считает агент, через запятую, пусто - все", stringValue{&c.AgentOperations}},
+		{"agent_max_concurrency", "AGENT_MAX_CONCURRENCY", nil, "одновременных задач агента по операциям, например /=1,*=2", stringValue{&c.AgentMaxConcurrency}},
+		{"state_file", "STATE_FILE", nil, "файл для сохранения выражений между запусками, пусто - не сохранять", stringValue{&c.StateFile}},
+	}
+}
+
+// Собирает конфигурацию: значения по умолчанию, затем файл (флаг -config или
+// CONFIG_FILE), затем переменные окружения, затем флаги. Каждый следующий слой
+// перекрывает предыдущий. Флаги настроек регистрируются в fs, args разбираются
+// им же. Любое неверное значение - ошибка, а не тихий откат к умолчанию
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	c := Default()
+	c.sources = make(map[string]string)
+	settings := c.settings()
+
+	flagValues := make(map[string]string)
+	probe := Default() // Флаги применяются последними, а при разборе только проверяются на копии
+	for i, s := range probe.settings() {
+		key := s.key
+		usage := s.usage + " (по умолчанию " + settings[i].value.String() + ")"
+		set := func(raw string) error {
+			if err := s.value.set(raw); err != nil {
+				return err
+			}
+			flagValues[key] = raw
+			return nil
+		}
+		if _, ok := s.value.(boolValue); ok {
+			fs.BoolFunc(flagName(key), usage, set) // -fold-constants без значения означает true
+		} else {
+			fs.Func(flagName(key), usage, set)
+		}
+	}
+	file := fs.String("config", os.Getenv(ConfigFileEnv), "файл конфигурации (TOML: key = value)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return c, err
+		}
+		return c, fmt.Errorf("%w: %v", ErrUsage, err)
+	}
+
+	var errs []error
+	if *file != "" {
+		c.File = *file
+		errs = append(errs, c.loadFile(*file, settings)...)
+	}
+	for _, s := range settings {
+		for _, name := range append([]string{s.env}, s.legacy...) {
+			raw, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if err := s.value.set(raw); err != nil {
+				errs = append(errs, fmt.Errorf("environment %s: %w", name, err))
+				continue
+			}
+			c.sources[s.key] = "env " + name
+		}
+	}
+	for _, s := range settings {
+		if raw, ok := flagValues[s.key]; ok {
+			s.value.set(raw)
+			c.sources[s.key] = "flag -" + flagName(s.key)
+		}
+	}
+
+	errs = append(errs, c.Validate())
+	return c, errors.Join(errs...)
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+// Читает файл конфигурации: плоский TOML из строк key = value и комментариев #
+func (c *Config) loadFile(path string, settings []setting) []error {
+	f, err := os.Open(path)
+	if err != nil {
+		return []error{fmt.Errorf("config file: %w", err)}
+	}
+	defer f.Close()
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	var errs []error
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		where := fmt.Sprintf("%s:%d", path, line)
+		key, raw, ok := strings.Cut(text, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: expected key = value, got %q", where, text))
+			continue
+		}
+		key = strings.TrimSpace(key)
+		s, known := byKey[key]
+		if !known {
+			errs = append(errs, fmt.Errorf("%s: unknown key %q", where, key))
+			continue
+		}
+		value, err := fileValue(strings.TrimSpace(raw))
+		if err == nil {
+			err = s.value.set(value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", where, key, err))
+			continue
+		}
+		c.sources[key] = "file " + path
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("config file: %w", err))
+	}
+	return errs
+}
+
+// Снимает кавычки со строки и отрезает комментарий в конце строки
+func fileValue(raw string) (string, error) {
+	if strings.HasPrefix(raw, `"`) {
+		end := strings.LastIndex(raw, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		if rest := strings.TrimSpace(raw[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return strconv.Unquote(raw[:end+1])
+	}
+	if i := strings.Index(raw, "#"); i >= 0 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// Проверяет, что значения имеют смысл. Возвращает все найденные ошибки сразу
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	type named struct {
+		key   string
+		value int
+	}
+	check(c.ComputingPower >= 1, "computing_power must be at least 1, got %d", c.ComputingPower)
+	for _, t := range []named{
+		{"time_addition_ms", c.TimeAdditionMS},
+		{"time_subtraction_ms", c.TimeSubtractionMS},
+		{"time_multiplication_ms", c.TimeMultiplicationMS},
+		{"time_division_ms", c.TimeDivisionMS},
+		{"time_modulo_ms", c.TimeModuloMS},
+		{"time_floor_division_ms", c.TimeFloorDivisionMS},
+	} {
+		check(t.value >= 0, "%s must not be negative, got %d", t.key, t.value)
+	}
+	if _, port, err := net.SplitHostPort(c.OrchestratorAddr); err != nil {
+		errs = append(errs, fmt.Errorf("orchestrator_addr %q: %w", c.OrchestratorAddr, err))
+	} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		errs = append(errs, fmt.Errorf("orchestrator_addr %q: port must be from 1 to 65535", c.OrchestratorAddr))
+	}
+	for _, n := range []named{
+		{"rate_limit_ip_per_min", c.RateLimitIPPerMin},
+		{"rate_limit_ip_burst", c.RateLimitIPBurst},
+		{"rate_limit_user_per_min", c.RateLimitUserPerMin},
+		{"rate_limit_user_burst", c.RateLimitUserBurst},
+		{"daily_quota", c.DailyQuota},
+		{"max_expression_length", c.MaxExpressionLength},
+		{"max_tasks", c.MaxTasks},
+		{"cache_size", c.CacheSize},
+		{"drain_timeout_sec", c.DrainTimeoutSec},
+		{"expression_timeout_sec", c.ExprTimeoutSec},
+	} {
+		check(n.value >= 0, "%s must not be negative, got %d", n.key, n.value)
+	}
+	check(c.RetryInitialMS >= 1, "retry_initial_ms must be at least 1, got %d", c.RetryInitialMS)
+	check(c.RetryMaxMS >= c.RetryInitialMS, "retry_max_ms must not be less than retry_initial_ms (%d), got %d", c.RetryInitialMS, c.RetryMaxMS)
+	check(c.RetryMaxElapsedSec >= 1, "retry_max_elapsed_sec must be at least 1, got %d", c.RetryMaxElapsedSec)
+	check(c.RetryJitterPercent >= 0 && c.RetryJitterPercent <= 100, "retry_jitter_percent must be from 0 to 100, got %d", c.RetryJitterPercent)
+	check(c.BreakerFailures >= 1, "breaker_failures must be at least 1, got %d", c.BreakerFailures)
+	check(c.BreakerCooldownMS >= 1, "breaker_cooldown_ms must be at least 1, got %d", c.BreakerCooldownMS)
+	check(c.LeaseTimeoutSec >= 1, "lease_timeout_sec must be at least 1, got %d", c.LeaseTimeoutSec)
+	check(c.IdempotencyTTLSec >= 1, "idempotency_ttl_sec must be at least 1, got %d", c.IdempotencyTTLSec)
+	if _, err := c.agentCapabilities(); err != nil {
+		errs = append(errs, err)
+	}
+	check(c.CacheSize == 0 || c.CacheTTLSec >= 1, "cache_ttl_sec must be at least 1 when the cache is enabled, got %d", c.CacheTTLSec)
+	return errors.Join(errs...)
+}
+
+// Пишет итоговую конфигурацию в формате файла, с источником каждого значения
+func (c Config) Write(w io.Writer) error {
+	var b strings.Builder
+	for _, s := range c.settings() {
+		source := c.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(&b, "%s = %s # %s\n", s.key, s.value.String(), source)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,142 @@
+package env
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Статистика перезагрузок конфигурации
+type ReloadStats struct {
+	Succeeded  uint64    `json:"succeeded"`
+	Failed     uint64    `json:"failed"`
+	LastReload time.Time `json:"last_reload,omitempty"` // Последняя успешная перезагрузка
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// Перечитывает конфигурацию по сигналу или при изменении файла и передаёт
+// её подписчикам. Неверная конфигурация не применяется, работает прежняя
+type Reloader struct {
+	load func() (Config, error)
+
+	mu        sync.Mutex
+	current   Config
+	listeners []func(Config)
+	stats     ReloadStats
+}
+
+// Создаёт Reloader с уже загруженной конфигурацией; load собирает её заново
+func NewReloader(current Config, load func() (Config, error)) *Reloader {
+	return &Reloader{load: load, current: current}
+}
+
+// Добавляет подписчика, который получит новую конфигурацию
+func (r *Reloader) OnReload(fn func(Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Перечитывает конфигурацию; reason попадает в журнал
+func (r *Reloader) Reload(reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	config, err := r.load()
+	if err != nil {
+		r.stats.Failed++
+		r.stats.LastError = err.Error()
+		log.Printf("Перезагрузка конфигурации (%s) не удалась, работает прежняя: %v", reason, err)
+		return err
+	}
+
+	changed := Diff(r.current, config)
+	r.current = config
+	r.stats.Succeeded++
+	r.stats.LastReload = time.Now()
+	r.stats.LastError = ""
+	log.Printf("Конфигурация перезагружена (%s), изменено: %v", reason, changed)
+	for _, key := range changed {
+		if !hotReloadable[key] {
+			log.Printf("Параметр %s применится только после перезапуска", key)
+		}
+	}
+	for _, fn := range r.listeners {
+		fn(config)
+	}
+	return nil
+}
+
+// Следит за файлом конфигурации и перечитывает его при изменении,
+// пока не отменён ctx. Без файла ничего не делает
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	r.mu.Lock()
+	path := r.current.File
+	r.mu.Unlock()
+	if path == "" {
+		return
+	}
+
+	last := fileVersion(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if version := fileVersion(path); version != last {
+				last = version
+				r.Reload("изменён " + path)
+			}
+		}
+	}
+}
+
+// Текущая конфигурация
+func (r *Reloader) Current() Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Статистика перезагрузок
+func (r *Reloader) Stats() ReloadStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Время изменения и размер файла: по ним видно, что файл переписали
+func fileVersion(path string) [2]int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return [2]int64{}
+	}
+	return [2]int64{info.ModTime().UnixNano(), info.Size()}
+}
+
+// Параметры, которые применяются без перезапуска
+var hotReloadable = map[string]bool{
+	"computing_power":        true,
+	"time_addition_ms":       true,
+	"time_subtraction_ms":    true,
+	"time_multiplication_ms": true,
+	"time_division_ms":       true,
+	"time_modulo_ms":         true,
+	"time_floor_division_ms": true,
+}
+
+// Ключи параметров, которые отличаются в двух конфигурациях
+func Diff(a, b Config) []string {
+	as, bs := a.settings(), b.settings()
+	var changed []string
+	for i := range as {
+		if as[i].value.String() != bs[i].value.String() {
+			changed = append(changed, as[i].key)
+		}
+	}
+	return changed
+}
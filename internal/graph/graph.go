@@ -0,0 +1,160 @@
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/NieR8/myProject/internal/store"
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/parser"
+)
+
+// Граф выражения: дерево операций, в котором одинаковые поддеревья
+// сведены в одну задачу, с состоянием каждой задачи
+type Graph struct {
+	ExpressionID int           `json:"expression_id"`
+	Name         string        `json:"name"`
+	Status       models.Status `json:"status"`
+	Root         string        `json:"root,omitempty"`
+	Nodes        []Node        `json:"nodes"`
+	Edges        []Edge        `json:"edges"`
+}
+
+// Узел графа: операция (задача) или число
+type Node struct {
+	ID         string     `json:"id"`
+	Label      string     `json:"label"`
+	Kind       string     `json:"kind"` // operation или number
+	TaskID     string     `json:"task_id,omitempty"`
+	Operation  string     `json:"operation,omitempty"`
+	Arg1       string     `json:"arg1,omitempty"`
+	Arg2       string     `json:"arg2,omitempty"`
+	State      string     `json:"state,omitempty"`
+	Agent      string     `json:"agent,omitempty"`
+	Result     *float64   `json:"result,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMS int64      `json:"duration_ms,omitempty"`
+
+	depth int // Слой при отрисовке: самый длинный путь от корня
+	x     float64
+}
+
+// Ребро от операции к её операнду
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Side string `json:"side"` // left или right; у условия ещё cond
+}
+
+const (
+	KindOperation = "operation"
+	KindNumber    = "number"
+)
+
+// Строит граф выражения по его дереву и задачам из хранилища
+func Build(expr models.Expression, tasks []store.TaskInfo) *Graph {
+	g := &Graph{ExpressionID: expr.Id, Name: expr.Name, Status: expr.Status}
+	byTask := make(map[string]store.TaskInfo, len(tasks))
+	for _, task := range tasks {
+		byTask[task.ID] = task
+	}
+
+	index := make(map[string]int) // ID узла -> позиция в Nodes
+	counter := 0
+	var add func(node *models.Node, depth int) string
+	add = func(node *models.Node, depth int) string {
+		id := node.TaskID
+		if id == "" {
+			id = "n" + strconv.Itoa(counter)
+			counter++
+		}
+		if i, seen := index[id]; seen {
+			if depth > g.Nodes[i].depth {
+				g.Nodes[i].depth = depth
+			}
+			// Общее поддерево уже добавлено, но его потомки должны опуститься вслед за ним
+			for _, e := range g.Edges {
+				if e.From == id {
+					g.deepen(e.To, depth+1, index)
+				}
+			}
+			return id
+		}
+
+		n := Node{ID: id, Label: node.Value, Kind: KindNumber, depth: depth}
+		if parser.IsCompound(node.Value) {
+			n.Kind = KindOperation
+			n.Operation = node.Value
+			n.TaskID = node.TaskID
+			if task, ok := byTask[node.TaskID]; ok {
+				n.Arg1, n.Arg2 = task.Arg1, task.Arg2
+				n.State, n.Agent = task.State, task.Agent
+				n.StartedAt, n.FinishedAt, n.DurationMS = task.StartedAt, task.FinishedAt, task.DurationMS
+				if task.Completed {
+					result := task.Result
+					n.Result = &result
+				}
+			}
+		} else if num, err := strconv.ParseFloat(node.Value, 64); err == nil {
+			n.Label = strconv.FormatFloat(num, 'g', -1, 64)
+		}
+		index[id] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, n)
+
+		if node.Cond != nil {
+			g.Edges = append(g.Edges, Edge{From: id, To: add(node.Cond, depth+1), Side: "cond"})
+		}
+		if node.Left != nil {
+			g.Edges = append(g.Edges, Edge{From: id, To: add(node.Left, depth+1), Side: "left"})
+		}
+		if node.Right != nil {
+			g.Edges = append(g.Edges, Edge{From: id, To: add(node.Right, depth+1), Side: "right"})
+		}
+		return id
+	}
+	if expr.Node != nil {
+		g.Root = add(expr.Node, 0)
+	}
+	return g
+}
+
+// Опускает узел и его потомков не выше заданного слоя
+func (g *Graph) deepen(id string, depth int, index map[string]int) {
+	i := index[id]
+	if g.Nodes[i].depth >= depth {
+		return
+	}
+	g.Nodes[i].depth = depth
+	for _, e := range g.Edges {
+		if e.From == id {
+			g.deepen(e.To, depth+1, index)
+		}
+	}
+}
+
+// Подпись узла для DOT и Mermaid: операция, задача, состояние, агент и время
+func (n Node) details() []string {
+	lines := []string{n.Label}
+	if n.Kind != KindOperation {
+		return lines
+	}
+	if n.TaskID != "" {
+		lines = append(lines, n.TaskID)
+	}
+	status := n.State
+	if n.Result != nil {
+		status += " = " + strconv.FormatFloat(*n.Result, 'g', -1, 64)
+	}
+	if status != "" {
+		lines = append(lines, status)
+	}
+	if n.Agent != "" {
+		lines = append(lines, "agent "+n.Agent)
+	}
+	if n.DurationMS > 0 {
+		lines = append(lines, fmt.Sprintf("%d ms", n.DurationMS))
+	}
+	return lines
+}
@@ -0,0 +1,49 @@
+package graph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/NieR8/myProject/internal/store"
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/parser"
+)
+
+func TestBuildSharesSubtrees(t *testing.T) {
+	// (2+3)*(3+2): оба сомножителя - одна задача
+	root := &models.Node{Value: "*",
+		Left:  &models.Node{Value: "+", Left: &models.Node{Value: "2"}, Right: &models.Node{Value: "3"}},
+		Right: &models.Node{Value: "+", Left: &models.Node{Value: "3"}, Right: &models.Node{Value: "2"}},
+	}
+	tasks, err := parser.BuildTasks("expr-1", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := make([]store.TaskInfo, len(tasks))
+	for i, task := range tasks {
+		infos[i] = store.TaskInfo{Task: task, State: store.TaskWaiting}
+	}
+
+	g := Build(models.Expression{Id: 1, Name: "(2+3)*(3+2)", Node: root}, infos)
+	if len(g.Nodes) != 4 || len(g.Edges) != 4 {
+		t.Fatalf("got %d nodes and %d edges, want 4 and 4", len(g.Nodes), len(g.Edges))
+	}
+	if g.Root != "task-expr-1-1" {
+		t.Errorf("root = %q, want task-expr-1-1", g.Root)
+	}
+
+	for name, write := range map[string]func(*bytes.Buffer, *Graph) error{
+		"dot":     func(b *bytes.Buffer, g *Graph) error { return WriteDOT(b, g) },
+		"mermaid": func(b *bytes.Buffer, g *Graph) error { return WriteMermaid(b, g) },
+		"svg":     func(b *bytes.Buffer, g *Graph) error { return WriteSVG(b, g) },
+	} {
+		var b bytes.Buffer
+		if err := write(&b, g); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !strings.Contains(b.String(), "task-expr-1-0") {
+			t.Errorf("%s output has no task label:\n%s", name, b.String())
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package graph
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Цвета состояний задач, общие для DOT и SVG
+var stateColors = map[string]string{
+	"waiting":   "#f6e7b0",
+	"ready":     "#f6e7b0",
+	"leased":    "#b6d7ff",
+	"done":      "#b7e4c0",
+	"failed":    "#ffc1c1",
+	"cancelled": "#d8dee4",
+}
+
+func (n Node) fill() string {
+	if n.Kind == KindNumber {
+		return "#ffffff"
+	}
+	if color, ok := stateColors[n.State]; ok {
+		return color
+	}
+	return "#eaeef2"
+}
+
+// Пишет граф в формате Graphviz DOT
+func WriteDOT(w io.Writer, g *Graph) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph expr_%d {\n", g.ExpressionID)
+	fmt.Fprintf(&b, "  label=%s;\n  labelloc=t;\n", dotQuote(g.Name))
+	b.WriteString("  node [fontname=\"monospace\", style=filled];\n")
+	for _, n := range g.Nodes {
+		shape := "box"
+		if n.Kind == KindNumber {
+			shape = "ellipse"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s, fillcolor=%s];\n",
+			dotQuote(n.ID), dotQuote(strings.Join(n.details(), "\n")), shape, dotQuote(n.fill()))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", dotQuote(e.From), dotQuote(e.To), dotQuote(e.Side))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+// Пишет граф в формате Mermaid flowchart
+func WriteMermaid(w io.Writer, g *Graph) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	for _, n := range g.Nodes {
+		label := mermaidQuote(strings.Join(n.details(), "<br/>"))
+		if n.Kind == KindNumber {
+			fmt.Fprintf(&b, "  %s((%s))\n", mermaidID(n.ID), label)
+		} else {
+			fmt.Fprintf(&b, "  %s[%s]\n", mermaidID(n.ID), label)
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&b, "  %s -->|%s| %s\n", mermaidID(e.From), e.Side, mermaidID(e.To))
+	}
+	for _, n := range g.Nodes {
+		if n.Kind == KindOperation && n.State != "" {
+			fmt.Fprintf(&b, "  style %s fill:%s\n", mermaidID(n.ID), n.fill())
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// В идентификаторах Mermaid дефис допустим, но в начале строки ломает разбор стрелок
+func mermaidID(id string) string {
+	return strings.ReplaceAll(id, "-", "_")
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
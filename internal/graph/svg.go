@@ -0,0 +1,97 @@
+package graph
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// Размеры элементов SVG в пикселях
+const (
+	svgNodeWidth  = 150
+	svgLineHeight = 15
+	svgColumn     = 170
+	svgRow        = 110
+	svgMargin     = 20
+)
+
+// Рисует граф в SVG: слой узла - длина самого длинного пути от корня,
+// листья расставляются слева направо, операции - над серединой своих операндов
+func WriteSVG(w io.Writer, g *Graph) error {
+	index := make(map[string]int, len(g.Nodes))
+	children := make(map[string][]string)
+	for i, n := range g.Nodes {
+		index[n.ID] = i
+	}
+	for _, e := range g.Edges {
+		children[e.From] = append(children[e.From], e.To)
+	}
+
+	placed := make(map[string]bool)
+	slot := 0
+	var place func(id string) float64
+	place = func(id string) float64 {
+		n := &g.Nodes[index[id]]
+		if placed[id] {
+			return n.x
+		}
+		placed[id] = true
+		if len(children[id]) == 0 {
+			n.x = float64(slot)
+			slot++
+			return n.x
+		}
+		sum := 0.0
+		for _, child := range children[id] {
+			sum += place(child)
+		}
+		n.x = sum / float64(len(children[id]))
+		return n.x
+	}
+	if g.Root != "" {
+		place(g.Root)
+	}
+
+	maxDepth := 0
+	for _, n := range g.Nodes {
+		if n.depth > maxDepth {
+			maxDepth = n.depth
+		}
+	}
+	width := svgMargin*2 + max(slot, 1)*svgColumn
+	height := svgMargin*2 + (maxDepth+1)*svgRow
+
+	center := func(n Node) (float64, float64) {
+		return svgMargin + n.x*svgColumn + svgColumn/2, float64(svgMargin + n.depth*svgRow + svgRow/2)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="monospace" font-size="12">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(g.Name))
+	for _, e := range g.Edges {
+		x1, y1 := center(g.Nodes[index[e.From]])
+		x2, y2 := center(g.Nodes[index[e.To]])
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#57606a"/>`+"\n", x1, y1, x2, y2)
+	}
+	for _, n := range g.Nodes {
+		x, y := center(n)
+		lines := n.details()
+		h := float64(len(lines)*svgLineHeight + 10)
+		if n.Kind == KindNumber {
+			fmt.Fprintf(&b, `<ellipse cx="%.1f" cy="%.1f" rx="%d" ry="%.1f" fill="%s" stroke="#57606a"/>`+"\n",
+				x, y, svgNodeWidth/3, h/2+2, n.fill())
+		} else {
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%d" height="%.1f" rx="6" fill="%s" stroke="#57606a"/>`+"\n",
+				x-svgNodeWidth/2, y-h/2, svgNodeWidth, h, n.fill())
+		}
+		for i, line := range lines {
+			ty := y - h/2 + 5 + float64((i+1)*svgLineHeight) - 3
+			fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n", x, ty, html.EscapeString(line))
+		}
+	}
+	b.WriteString("</svg>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
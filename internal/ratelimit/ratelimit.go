@@ -0,0 +1,162 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Результат проверки лимита, по нему выставляются заголовки X-RateLimit-*
+type Decision struct {
+	Allowed    bool
+	Limit      int           // Размер лимита (ёмкость корзины или дневная квота)
+	Remaining  int           // Сколько запросов ещё можно сделать
+	Reset      time.Time     // Когда лимит полностью восстановится
+	RetryAfter time.Duration // Через сколько повторить запрос, если он отклонён
+}
+
+// Ограничивает частоту запросов алгоритмом token bucket, отдельная корзина на каждый ключ
+type Limiter struct {
+	mu        sync.Mutex
+	rate      float64 // Токенов в секунду
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Создаёт лимитер на perMinute запросов в минуту с запасом burst. При perMinute <= 0 лимит отключён
+func NewLimiter(perMinute, burst int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Списывает токен для ключа. Nil-лимитер пропускает всё
+func (l *Limiter) Allow(key string, now time.Time) Decision {
+	if l == nil {
+		return Decision{Allowed: true, Limit: -1}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	decision := Decision{Limit: int(l.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = l.duration(1 - b.tokens)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = now.Add(l.duration(l.burst - b.tokens))
+	return decision
+}
+
+// Возвращает токен, списанный Allow для ключа. Nil-лимитер ничего не делает
+func (l *Limiter) Refund(key string, now time.Time) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = math.Min(l.burst, b.tokens+1)
+	}
+}
+
+// Время, за которое накопится n токенов
+func (l *Limiter) duration(n float64) time.Duration {
+	return time.Duration(n / l.rate * float64(time.Second))
+}
+
+// Раз в минуту удаляет полные корзины, чтобы память не росла от разовых клиентов
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Считает запросы каждого ключа за текущие сутки по UTC
+type Quota struct {
+	mu     sync.Mutex
+	limit  int
+	day    time.Time
+	counts map[string]int
+}
+
+// Создаёт дневную квоту на limit запросов. При limit <= 0 квота отключена
+func NewQuota(limit int) *Quota {
+	if limit <= 0 {
+		return nil
+	}
+	return &Quota{limit: limit, counts: make(map[string]int)}
+}
+
+// Засчитывает запрос ключа, если квота на сегодня не исчерпана. Nil-квота пропускает всё
+func (q *Quota) Allow(key string, now time.Time) Decision {
+	if q == nil {
+		return Decision{Allowed: true, Limit: -1}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if !day.Equal(q.day) {
+		q.day = day
+		q.counts = make(map[string]int) // Новые сутки - счётчики обнуляются
+	}
+	reset := day.AddDate(0, 0, 1)
+
+	decision := Decision{Limit: q.limit, Reset: reset}
+	if q.counts[key] >= q.limit {
+		decision.RetryAfter = reset.Sub(now)
+		return decision
+	}
+	q.counts[key]++
+	decision.Allowed = true
+	decision.Remaining = q.limit - q.counts[key]
+	return decision
+}
+
+// Отменяет запрос ключа, засчитанный Allow сегодня. Nil-квота ничего не делает
+func (q *Quota) Refund(key string, now time.Time) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if day.Equal(q.day) && q.counts[key] > 0 {
+		q.counts[key]--
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(60, 2) // Токен в секунду, запас 2
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if d := limiter.Allow("1.2.3.4", now); !d.Allowed {
+			t.Fatalf("request %d rejected within burst: %+v", i, d)
+		}
+	}
+	d := limiter.Allow("1.2.3.4", now)
+	if d.Allowed || d.RetryAfter <= 0 || d.RetryAfter > time.Second {
+		t.Errorf("request over burst = %+v, want rejection with RetryAfter up to 1s", d)
+	}
+	if d := limiter.Allow("5.6.7.8", now); !d.Allowed {
+		t.Errorf("other client rejected: %+v", d)
+	}
+	if d := limiter.Allow("1.2.3.4", now.Add(time.Second)); !d.Allowed {
+		t.Errorf("request after refill rejected: %+v", d)
+	}
+	if d := NewLimiter(0, 0).Allow("1.2.3.4", now); !d.Allowed {
+		t.Errorf("disabled limiter rejected request: %+v", d)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	quota := NewQuota(2)
+	now := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if d := quota.Allow("alice", now); !d.Allowed || d.Remaining != 1-i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i, d, 1-i)
+		}
+	}
+	d := quota.Allow("alice", now)
+	if d.Allowed || d.RetryAfter != time.Hour {
+		t.Errorf("request over quota = %+v, want rejection until midnight", d)
+	}
+	if d := quota.Allow("alice", now.Add(time.Hour)); !d.Allowed {
+		t.Errorf("request on the next day rejected: %+v", d)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter, quota := NewLimiter(1, 1), NewQuota(1)
+
+	limiter.Allow("1.2.3.4", now)
+	limiter.Refund("1.2.3.4", now)
+	if d := limiter.Allow("1.2.3.4", now); !d.Allowed {
+		t.Errorf("refunded token was not returned: %+v", d)
+	}
+
+	quota.Allow("alice", now)
+	quota.Refund("alice", now)
+	if d := quota.Allow("alice", now); !d.Allowed || d.Remaining != 0 {
+		t.Errorf("refunded request still counted: %+v", d)
+	}
+	NewLimiter(0, 0).Refund("1.2.3.4", now) // Отключённые лимиты ничего не возвращают
+	NewQuota(0).Refund("alice", now)
+}
@@ -0,0 +1,104 @@
+package retry
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Состояние предохранителя
+type State int
+
+const (
+	Closed   State = iota // Запросы идут как обычно
+	Open                  // Сервис недоступен, запросы ждут конца паузы
+	HalfOpen              // Пауза кончилась, один пробный запрос проверяет сервис
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// Предохранитель (circuit breaker): после threshold неудач подряд запросы
+// приостанавливаются на cooldown, затем один пробный запрос решает,
+// возобновить их или снова ждать
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	openUntil time.Time // Конец паузы; в HalfOpen - срок ответа пробного запроса
+}
+
+// Создаёт предохранитель; name попадает в журнал
+func NewBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown}
+}
+
+// Ждёт, пока предохранитель пропустит запрос, или отмены ctx. Пока идёт
+// пробный запрос, остальные ждут его результата
+func (b *Breaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		if b.state == Closed {
+			b.mu.Unlock()
+			return nil
+		}
+		if !now.Before(b.openUntil) { // Пауза кончилась или пробный запрос не ответил вовремя
+			b.state = HalfOpen
+			b.openUntil = now.Add(b.cooldown)
+			b.mu.Unlock()
+			return nil // Этот запрос - пробный
+		}
+		wait := b.openUntil.Sub(now)
+		b.mu.Unlock()
+		if err := Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Отмечает успешный запрос
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Closed {
+		log.Printf("[%s] Связь восстановлена, запросы возобновлены", b.name)
+	}
+	b.state = Closed
+	b.failures = 0
+}
+
+// Отмечает неудачный запрос: сервис не ответил или ответил 5xx
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == HalfOpen || b.state == Closed && b.failures >= b.threshold {
+		b.state = Open
+		b.openUntil = time.Now().Add(b.cooldown)
+		log.Printf("[%s] %d неудачных запросов подряд, пауза %s", b.name, b.failures, b.cooldown)
+	}
+}
+
+// Текущее состояние
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,113 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Политика повторов: экспоненциальная пауза со случайным разбросом,
+// ограниченная по числу попыток и общему времени
+type Policy struct {
+	InitialInterval time.Duration // Пауза после первой неудачи
+	MaxInterval     time.Duration // Пауза не растёт больше этого значения
+	Multiplier      float64       // Во сколько раз растёт пауза с каждой попыткой
+	Jitter          float64       // Разброс паузы, доля от 0 до 1: 0.2 - ±20%
+	MaxElapsed      time.Duration // Сколько всего можно повторять, 0 - без ограничения
+	MaxAttempts     int           // Сколько всего попыток, 0 - без ограничения
+}
+
+// Ошибка ответа с HTTP-кодом. 5xx и 429 повторяются, остальные 4xx - нет
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Помечает ошибку как неисправимую повтором
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Можно ли надеяться, что повтор пройдёт. Ошибки сети (в том числе отказ
+// в соединении) и ответы 5xx и 429 повторяются, остальные 4xx и Permanent - нет
+func IsRetryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code >= 500 || status.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// Пауза перед повтором номер attempt (с нуля)
+func (p Policy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(p.InitialInterval) * math.Pow(multiplier, float64(attempt))
+	if p.MaxInterval > 0 && d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		d *= 1 - p.Jitter + 2*p.Jitter*rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Вызывает fn, пока она не вернёт nil или неповторяемую ошибку, не кончатся
+// попытки или время, или не будет отменён ctx. Возвращает последнюю ошибку
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !IsRetryable(err) {
+			return err
+		}
+		if p.MaxAttempts > 0 && attempt+1 >= p.MaxAttempts {
+			return fmt.Errorf("%w (gave up after %d attempts)", err, attempt+1)
+		}
+		wait := p.Backoff(attempt)
+		if p.MaxElapsed > 0 && time.Since(start)+wait > p.MaxElapsed {
+			return fmt.Errorf("%w (gave up after %d attempts in %s)", err, attempt+1, time.Since(start).Round(time.Millisecond))
+		}
+		if err := Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Ждёт d или отмены ctx
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
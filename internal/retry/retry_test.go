@@ -0,0 +1,103 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDoRetriesOnlyRetryableErrors(t *testing.T) {
+	policy := Policy{InitialInterval: time.Millisecond, MaxInterval: 2 * time.Millisecond, Multiplier: 2, Jitter: 0.2, MaxAttempts: 5}
+
+	tests := []struct {
+		name     string
+		errs     []error // Ошибки попыток по порядку, дальше - успех
+		calls    int
+		wantFail bool
+	}{
+		{"5xx then success", []error{&StatusError{Code: 503}, &StatusError{Code: 500}}, 3, false},
+		{"connection refused", []error{errors.New("connection refused")}, 2, false},
+		{"429 is retried", []error{&StatusError{Code: 429}}, 2, false},
+		{"4xx is final", []error{&StatusError{Code: 422}}, 1, true},
+		{"permanent", []error{Permanent(errors.New("bad body"))}, 1, true},
+		{"attempts exhausted", []error{errors.New("a"), errors.New("b"), errors.New("c"), errors.New("d"), errors.New("e"), errors.New("f")}, 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := policy.Do(context.Background(), func(context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.calls || (err != nil) != tt.wantFail {
+				t.Errorf("Do() called fn %d times, err = %v; want %d calls, fail = %v", calls, err, tt.calls, tt.wantFail)
+			}
+		})
+	}
+}
+
+func TestBackoffGrowsUpToMax(t *testing.T) {
+	policy := Policy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Multiplier: 2, Jitter: 0.2}
+	for attempt, base := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		base *= time.Millisecond
+		got := policy.Backoff(attempt)
+		if got < base*8/10 || got > base*12/10 {
+			t.Errorf("Backoff(%d) = %s, want %s ±20%%", attempt, got, base)
+		}
+	}
+}
+
+func TestDoStopsAtMaxElapsed(t *testing.T) {
+	policy := Policy{InitialInterval: 20 * time.Millisecond, Multiplier: 1, MaxElapsed: 50 * time.Millisecond}
+	calls := 0
+	err := policy.Do(context.Background(), func(context.Context) error {
+		calls++
+		return errors.New("down")
+	})
+	if err == nil || calls != 3 {
+		t.Errorf("Do() = %v after %d calls, want error after 3 calls", err, calls)
+	}
+}
+
+func TestBreakerPausesAndRecovers(t *testing.T) {
+	b := NewBreaker("test", 2, 50*time.Millisecond)
+	b.Failure()
+	if b.State() != Closed {
+		t.Fatalf("State() = %s after one failure, want closed", b.State())
+	}
+	b.Failure()
+	if b.State() != Open {
+		t.Fatalf("State() = %s after threshold, want open", b.State())
+	}
+
+	start := time.Now()
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("Wait() returned after %s, want about the cooldown", waited)
+	}
+	if b.State() != HalfOpen {
+		t.Fatalf("State() = %s after cooldown, want half-open", b.State())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second caller during probe: Wait() = %v, want to wait for the probe", err)
+	}
+
+	b.Failure() // Пробный запрос не прошёл - снова пауза
+	if b.State() != Open {
+		t.Fatalf("State() = %s after failed probe, want open", b.State())
+	}
+	b.Wait(context.Background())
+	b.Success()
+	if b.State() != Closed || b.Wait(context.Background()) != nil {
+		t.Errorf("State() = %s after successful probe, want closed", b.State())
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+)
+
+// Агент считается на связи, если обращался к оркестратору не позже этого срока
+const AgentOnlineTimeout = 30 * time.Second
+
+// Запоминает возможности агента. Повторная регистрация заменяет прежние
+func (s *Store) RegisterAgent(agentID string, caps models.Capabilities) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	agent := s.touchAgent(agentID, time.Now())
+	agent.Capabilities = &caps
+	s.notify() // Агенту могут подойти задачи, которые раньше было некому выдать
+}
+
+// Регистрировался ли агент с тех пор, как запущен оркестратор
+func (s *Store) AgentRegistered(agentID string) bool {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	agent, ok := s.agents[agentID]
+	return ok && agent.Capabilities != nil
+}
+
+// Может ли агент взять задачу сейчас: умеет её операцию и не упёрся в
+// ограничение на одновременные задачи с ней. Вызывается под s.Mu
+func (s *Store) canRun(agentID string, task models.Task) bool {
+	agent, ok := s.agents[agentID]
+	if !ok || agent.Capabilities == nil {
+		return !task.Decimal
+	}
+	caps := agent.Capabilities
+	if !supports(caps, task) {
+		return false
+	}
+	limit, limited := caps.MaxConcurrency[task.Operation]
+	if !limited {
+		return true
+	}
+	running := 0
+	for _, id := range agent.InFlight {
+		if s.Tasks[id].Operation == task.Operation {
+			running++
+		}
+	}
+	return running < limit
+}
+
+// Почему готовую задачу не может взять ни один агент на связи; пусто - есть
+// кому выдать. Ограничения на одновременные задачи не в счёт: они временные.
+// Вызывается под s.Mu
+func (s *Store) blockedReason(task models.Task, now time.Time) string {
+	online, operation := 0, false
+	for _, agent := range s.agents {
+		if now.Sub(agent.LastSeen) > AgentOnlineTimeout {
+			continue
+		}
+		online++
+		if supports(agent.Capabilities, task) {
+			return ""
+		}
+		operation = operation || agent.Capabilities == nil || agent.Capabilities.Supports(task.Operation)
+	}
+	if online == 0 {
+		return "no agents online"
+	}
+	if operation {
+		return fmt.Sprintf("no online agent supports operation %q in decimal mode", task.Operation)
+	}
+	return fmt.Sprintf("no online agent supports operation %q", task.Operation)
+}
+
+// Умеет ли агент с возможностями caps считать задачу. nil - агент не
+// регистрировался: он считает любые задачи, кроме десятичных
+func supports(caps *models.Capabilities, task models.Task) bool {
+	if caps == nil {
+		return !task.Decimal
+	}
+	return caps.Supports(task.Operation) && (caps.Decimal || !task.Decimal)
+}
+
+// Отмечает обращение агента
+func (s *Store) TouchAgent(agentID string) {
+	if agentID == "" {
+		return
+	}
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.touchAgent(agentID, time.Now())
+}
+
+func (s *Store) touchAgent(agentID string, now time.Time) *models.AgentInfo {
+	agent, ok := s.agents[agentID]
+	if !ok {
+		agent = &models.AgentInfo{ID: agentID, FirstSeen: now}
+		s.agents[agentID] = agent
+	}
+	agent.LastSeen = now
+	return agent
+}
+
+// Запоминает, что задача выдана агенту
+func (s *Store) AgentTookTask(agentID, taskID string) {
+	if agentID == "" {
+		return
+	}
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.assignTask(agentID, taskID, time.Now())
+}
+
+// Записывает задачу за агентом. Вызывается под s.Mu
+func (s *Store) assignTask(agentID, taskID string, now time.Time) {
+	agent := s.touchAgent(agentID, now)
+	agent.InFlight = append(agent.InFlight, taskID)
+	if m, ok := s.meta[taskID]; ok {
+		m.agent = agentID
+	}
+}
+
+// Запоминает, что агент вернул результат задачи
+func (s *Store) AgentFinishedTask(agentID, taskID string) {
+	if agentID == "" {
+		return
+	}
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	agent := s.touchAgent(agentID, time.Now())
+	for i, id := range agent.InFlight {
+		if id == taskID {
+			agent.InFlight = append(agent.InFlight[:i], agent.InFlight[i+1:]...)
+			agent.Completed++
+			break
+		}
+	}
+}
+
+// Убирает задачу из списка выданных агенту, не считая её выполненной. Вызывается под s.Mu
+func (s *Store) dropAgentTask(agentID, taskID string) {
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return
+	}
+	for i, id := range agent.InFlight {
+		if id == taskID {
+			agent.InFlight = append(agent.InFlight[:i], agent.InFlight[i+1:]...)
+			return
+		}
+	}
+}
+
+// Возвращает всех известных агентов
+func (s *Store) GetAgents() []models.AgentInfo {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	now := time.Now()
+	agents := make([]models.AgentInfo, 0, len(s.agents))
+	for _, agent := range s.agents {
+		info := *agent
+		info.InFlight = append([]string{}, agent.InFlight...)
+		if agent.Capabilities != nil {
+			caps := *agent.Capabilities
+			info.Capabilities = &caps
+		}
+		info.Online = now.Sub(agent.LastSeen) <= AgentOnlineTimeout
+		agents = append(agents, info)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].ID < agents[j].ID
+	})
+	return agents
+}
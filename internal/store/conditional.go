@@ -0,0 +1,136 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/parser"
+)
+
+// Разрешает задачи условий "?" выражения. Агентам они не выдаются: когда
+// условие посчитано, хранилище строит задачи выбранной ветки и записывает её
+// корень в Arg2, а когда посчитана ветка, завершает задачу условия её
+// значением. Повторяет, пока что-то меняется: ветка может оказаться числом
+// или условием, которое уже можно разрешить. Вызывается под s.Mu;
+// false, если выражение провалено
+func (s *Store) resolveConditionals(id int) bool {
+	for changed := true; changed; {
+		changed = false
+		var conditions []models.Task
+		for _, taskID := range s.exprTasks[id] {
+			if task := s.Tasks[taskID]; task.Operation == parser.Conditional && !task.Completed {
+				conditions = append(conditions, task)
+			}
+		}
+		for _, task := range conditions {
+			cond := s.operandValue(task.Arg1)
+			if cond == nil {
+				continue
+			}
+			if task.Arg2 == "" {
+				var err error
+				if task, err = s.chooseBranch(id, task, *cond); err != nil {
+					s.failTask(task, err.Error())
+					return false
+				}
+				changed = true
+			}
+			if value := s.operandValue(task.Arg2); value != nil {
+				s.completeConditional(task, *value)
+				changed = true
+			}
+		}
+	}
+	return true
+}
+
+// Строит задачи ветки, выбранной условием, и добавляет их в очередь. Поддеревья,
+// которые у выражения уже считаются, новых задач не получают; вместе с веткой
+// задач не больше MaxTasks. Дерево выражения копируется: выданные раньше копии
+// выражения остаются неизменными
+func (s *Store) chooseBranch(id int, task models.Task, cond float64) (models.Task, error) {
+	expr := s.Expressions[id]
+	tree := parser.Clone(expr.Node)
+	nodes := findConditions(tree, task.ID)
+	if len(nodes) == 0 {
+		return task, fmt.Errorf("condition %s is not in the expression tree", task.ID)
+	}
+
+	built := make(map[string]string)
+	for _, taskID := range s.exprTasks[id] {
+		built[s.Tasks[taskID].Hash] = taskID
+	}
+	branch := parser.Branch(nodes[0], cond)
+	arg, tasks, err := parser.BuildTasksFrom(fmt.Sprintf("expr-%d", id), branch, s.nextTaskIndex(id), built)
+	if err != nil {
+		return task, err
+	}
+	if total := len(s.exprTasks[id]) + len(tasks); s.MaxTasks > 0 && total > s.MaxTasks {
+		return task, fmt.Errorf("too complex: %d operations, limit is %d", total, s.MaxTasks)
+	}
+	for _, node := range nodes[1:] { // Одинаковые условия делят одну задачу и одну ветку
+		if cond != 0 {
+			node.Left = branch
+		} else {
+			node.Right = branch
+		}
+	}
+	expr.Node = tree
+	s.Expressions[id] = expr
+
+	task.Arg2 = arg
+	s.Tasks[task.ID] = task
+	key := s.queueKey(id)
+	for i := len(tasks) - 1; i >= 0; i-- {
+		s.addTask(tasks[i], id, key)
+	}
+	s.computeCriticalPaths(append([]models.Task{task}, tasks...))
+	s.enqueueTasks(tasks)
+	log.Printf("Условие %s = %v: выбрана ветка %s, задач %d", task.ID, cond, arg, len(tasks))
+	return task, nil
+}
+
+// Завершает задачу условия значением выбранной ветки
+func (s *Store) completeConditional(task models.Task, value float64) {
+	task.Result = value
+	task.Completed = true
+	s.Tasks[task.ID] = task
+	if m, ok := s.meta[task.ID]; ok {
+		m.finishedAt = time.Now()
+	}
+	s.wakeDependents(task.ID)
+	s.notify() // Задачи, ждавшие условие, стали готовы
+	if s.OnTaskDone != nil {
+		s.OnTaskDone(task)
+	}
+}
+
+// Узлы условия, которые считает задача taskID
+func findConditions(node *models.Node, taskID string) []*models.Node {
+	if node == nil {
+		return nil
+	}
+	if parser.IsConditional(node) && node.TaskID == taskID {
+		return []*models.Node{node}
+	}
+	var found []*models.Node
+	for _, child := range []*models.Node{node.Cond, node.Left, node.Right} {
+		found = append(found, findConditions(child, taskID)...)
+	}
+	return found
+}
+
+// Номер для следующей задачи выражения: на единицу больше наибольшего
+func (s *Store) nextTaskIndex(id int) int {
+	next := 0
+	for _, taskID := range s.exprTasks[id] {
+		if i, err := strconv.Atoi(taskID[strings.LastIndex(taskID, "-")+1:]); err == nil && i >= next {
+			next = i + 1
+		}
+	}
+	return next
+}
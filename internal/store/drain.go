@@ -0,0 +1,79 @@
+package store
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Несделанная работа: что останется, если остановить оркестратор сейчас
+type Backlog struct {
+	Expressions []int `json:"expressions"` // Незавершённые выражения
+	Queued      int   `json:"queued"`      // Задачи в очередях, ещё не выданные агентам
+	InFlight    int   `json:"in_flight"`   // Задачи у агентов, результат ещё не пришёл
+}
+
+// Возвращает несделанную работу
+func (s *Store) Backlog() Backlog {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	b := Backlog{Expressions: []int{}}
+	for id, expr := range s.Expressions {
+		if !expr.Status.IsFinal() {
+			b.Expressions = append(b.Expressions, id)
+		}
+	}
+	sort.Ints(b.Expressions)
+	for _, q := range s.Queues {
+		b.Queued += q.queued
+	}
+	for _, ids := range s.exprTasks { // Задачи завершённых выражений уже забыты, см. retire
+		for _, taskID := range ids {
+			if m := s.meta[taskID]; !m.dispatchedAt.IsZero() && m.finishedAt.IsZero() && !s.Tasks[taskID].Completed {
+				b.InFlight++
+			}
+		}
+	}
+	return b
+}
+
+// Возвращает в очередь задачу, которую агент взял, но не посчитал (например,
+// остановился). Задача, уже переданная другому агенту, не трогается.
+// false, если задачи нет
+func (s *Store) ReleaseTask(taskID, agentID string) bool {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	task, exists := s.Tasks[taskID]
+	if !exists {
+		return s.retired(taskID) // Задача завершённого выражения уже удалена, возвращать её некуда
+	}
+	s.dropAgentTask(agentID, taskID)
+	m, ok := s.meta[taskID]
+	if !ok {
+		return true // Выражение уже завершено
+	}
+	if task.Completed || m.failed || m.dispatchedAt.IsZero() || m.agent != agentID {
+		return true // Возвращать в очередь нечего
+	}
+	s.requeue(taskID, m, agentActor(agentID), "task "+taskID, time.Now())
+	log.Printf("Задача %s возвращена агентом %s в очередь", taskID, agentID)
+	return true
+}
+
+// Снова ставит выданную задачу в очередь и отмечает это в истории выражения.
+// Задачи завершённых выражений остаются вне очереди. Вызывается под s.Mu
+func (s *Store) requeue(taskID string, m *taskMeta, actor, detail string, now time.Time) {
+	expr, exists := s.Expressions[m.exprID]
+	if !exists || expr.Status.IsFinal() {
+		return
+	}
+	m.dispatchedAt = time.Time{}
+	m.leaseUntil = time.Time{}
+	m.agent = ""
+	m.enqueuedAt = now
+	s.enqueue(m)
+	recordEvent(&expr, EventReleased, actor, detail, now)
+	s.Expressions[m.exprID] = expr
+}
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+)
+
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// Типы событий в истории выражения
+const (
+	EventSubmitted = "submitted" // Выражение принято
+	EventQueued    = "queued"    // Задачи поставлены в очередь
+	EventStarted   = "started"   // Первая задача выдана агенту
+	EventCompleted = "completed" // Результат посчитан
+	EventFailed    = "failed"    // Выражение не посчиталось
+	EventInvalid   = "invalid"   // Выражение не прошло разбор или проверки
+	EventCancelled = "cancelled" // Выражение отменено
+	EventTimedOut  = "timed_out" // Выражение не посчиталось вовремя
+	EventReleased  = "released"  // Агент вернул задачу не посчитав, она снова в очереди
+)
+
+// Тип события, которым выражение переходит в статус
+func statusEvent(status models.Status) string {
+	switch status {
+	case models.StatusPending:
+		return EventSubmitted
+	case models.StatusRunning:
+		return EventStarted
+	default:
+		return string(status) // Остальные события названы так же, как статусы
+	}
+}
+
+// Дописывает событие в историю выражения и проставляет соответствующую метку времени
+func recordEvent(expr *models.Expression, event string, actor, detail string, now time.Time) {
+	expr.Events = append(expr.Events, models.Event{
+		Time:   now,
+		Type:   event,
+		Status: expr.Status,
+		Actor:  actor,
+		Detail: detail,
+	})
+	switch event {
+	case EventSubmitted:
+		expr.CreatedAt = now
+	case EventStarted:
+		expr.StartedAt = &now
+	case EventCompleted:
+		expr.CompletedAt = &now
+	case EventFailed, EventInvalid, EventTimedOut:
+		expr.FailedAt = &now
+	case EventCancelled:
+		expr.CancelledAt = &now
+	}
+}
+
+// Меняет статус выражения и записывает переход в историю. Недопустимый
+// переход не выполняется и возвращает ErrInvalidTransition
+func setStatus(expr *models.Expression, status models.Status, actor, detail string, now time.Time) error {
+	if !expr.Status.CanTransition(status) {
+		return fmt.Errorf("%w: expression %d %s -> %s", ErrInvalidTransition, expr.Id, expr.Status, status)
+	}
+	expr.Status = status
+	recordEvent(expr, statusEvent(status), actor, detail, now)
+	return nil
+}
+
+// Копия выражения для ответа API: с вычисляемыми полями и своей историей
+func (s *Store) view(expr models.Expression, now time.Time) models.Expression {
+	expr.Progress = s.progress(expr)
+	expr.Events = slices.Clone(expr.Events)
+	if !expr.CreatedAt.IsZero() {
+		end := now
+		for _, finished := range []*time.Time{expr.CompletedAt, expr.FailedAt, expr.CancelledAt} {
+			if finished != nil {
+				end = *finished
+			}
+		}
+		expr.DurationMS = end.Sub(expr.CreatedAt).Milliseconds()
+	}
+	return expr
+}
+
+// Имя агента для истории
+func agentActor(agentID string) string {
+	if agentID == "" {
+		return "agent"
+	}
+	return "agent " + agentID
+}
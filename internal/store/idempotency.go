@@ -0,0 +1,86 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Сохранённый ответ на запрос с ключом идемпотентности
+type IdempotentResponse struct {
+	Fingerprint string // Хэш тела исходного запроса
+	ExprID      int    // ID созданного выражения, 0 если выражение не создано
+	StatusCode  int
+	Body        []byte
+	Pending     bool // Исходный запрос ещё обрабатывается
+	CreatedAt   time.Time
+}
+
+// Резервирует ключ за запросом. Если ключ уже есть и не устарел, возвращает
+// сохранённую запись и false: запрос нужно не выполнять, а ответить по ней
+func (s *Store) ReserveIdempotencyKey(key, fingerprint string, now time.Time) (IdempotentResponse, bool) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	s.expireIdempotencyKeys(now)
+	if saved, exists := s.idempotency[key]; exists {
+		return *saved, false
+	}
+	s.idempotency[key] = &IdempotentResponse{Fingerprint: fingerprint, Pending: true, CreatedAt: now}
+	heap.Push(&s.idemExpiry, idempotencyKey{key: key, createdAt: now})
+	return IdempotentResponse{}, true
+}
+
+// Сохраняет ответ для зарезервированного ключа
+func (s *Store) CompleteIdempotencyKey(key string, exprID, statusCode int, body []byte) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	saved, exists := s.idempotency[key]
+	if !exists {
+		return
+	}
+	saved.ExprID = exprID
+	saved.StatusCode = statusCode
+	saved.Body = body
+	saved.Pending = false
+}
+
+// Освобождает ключ, если ответ сохранять не нужно (например, временная ошибка)
+func (s *Store) ReleaseIdempotencyKey(key string) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	delete(s.idempotency, key)
+}
+
+// Удаляет устаревшие ключи. Разбирает только их, а не все ключи.
+// Вызывается под s.Mu
+func (s *Store) expireIdempotencyKeys(now time.Time) {
+	for len(s.idemExpiry) > 0 && now.Sub(s.idemExpiry[0].createdAt) > s.IdempotencyTTL {
+		k := heap.Pop(&s.idemExpiry).(idempotencyKey)
+		if saved, exists := s.idempotency[k.key]; exists && saved.CreatedAt.Equal(k.createdAt) {
+			delete(s.idempotency, k.key)
+		}
+	}
+}
+
+// Ключ идемпотентности и время его резервирования
+type idempotencyKey struct {
+	key       string
+	createdAt time.Time
+}
+
+// Ключи по времени резервирования: сверху самый старый. Записи освобождённых
+// и заново зарезервированных ключей не удаляются, а пропускаются при разборе
+type idempotencyHeap []idempotencyKey
+
+func (h idempotencyHeap) Len() int            { return len(h) }
+func (h idempotencyHeap) Less(i, j int) bool  { return h[i].createdAt.Before(h[j].createdAt) }
+func (h idempotencyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *idempotencyHeap) Push(x interface{}) { *h = append(*h, x.(idempotencyKey)) }
+
+func (h *idempotencyHeap) Pop() interface{} {
+	old := *h
+	k := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return k
+}
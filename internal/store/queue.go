@@ -0,0 +1,161 @@
+package store
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+)
+
+// Готовые задачи очереди с одной операцией и режимом счёта: куча, сверху
+// задача, которую очередь выдаст первой
+type readyHeap []*taskMeta
+
+func (h readyHeap) Len() int           { return len(h) }
+func (h readyHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+func (h readyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex, h[j].heapIndex = i, j
+}
+
+func (h *readyHeap) Push(x interface{}) {
+	m := x.(*taskMeta)
+	m.heapIndex = len(*h)
+	*h = append(*h, m)
+}
+
+func (h *readyHeap) Pop() interface{} {
+	old := *h
+	m := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	m.heapIndex = -1
+	return m
+}
+
+// Аренда выданной задачи
+type lease struct {
+	taskID string
+	until  time.Time
+}
+
+// Аренды по сроку окончания: сверху та, что истекает первой. Записи о
+// вернувшихся и снова выданных задачах не удаляются, а пропускаются при разборе
+type leaseHeap []lease
+
+func (h leaseHeap) Len() int            { return len(h) }
+func (h leaseHeap) Less(i, j int) bool  { return h[i].until.Before(h[j].until) }
+func (h leaseHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *leaseHeap) Push(x interface{}) { *h = append(*h, x.(lease)) }
+
+func (h *leaseHeap) Pop() interface{} {
+	old := *h
+	l := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return l
+}
+
+// Выдаётся ли задача a раньше b: выражения по порядку поступления, в
+// выражении - по длине критического пути, при равенстве - по времени постановки
+func (m *taskMeta) before(other *taskMeta) bool {
+	if m.exprID != other.exprID {
+		return m.exprID < other.exprID
+	}
+	if m.critical != other.critical {
+		return m.critical > other.critical
+	}
+	return m.seq < other.seq
+}
+
+// Ставит задачу в очередь её выражения. Готовая задача сразу попадает в кучу
+// готовых, остальные ждут, пока wakeDependents не отметит их зависимости.
+// Вызывается под s.Mu
+func (s *Store) enqueue(m *taskMeta) {
+	q, ok := s.Queues[m.queue]
+	if !ok {
+		q = &TaskQueue{ready: make(map[string]*readyHeap), virtual: s.virtualClock} // Новая очередь не получает фору за время простоя
+		s.Queues[m.queue] = q
+	}
+	s.seq++
+	m.seq = s.seq
+	m.queued = true
+	q.queued++
+	if task := s.Tasks[m.id]; s.isTaskReady(task) {
+		q.push(readyKey(task), m)
+	}
+	s.notify()
+}
+
+// Убирает задачу из очереди: её выдали агенту или выражение завершилось.
+// Вызывается под s.Mu
+func (s *Store) dequeue(m *taskMeta) {
+	if !m.queued {
+		return
+	}
+	m.queued = false
+	q, ok := s.Queues[m.queue]
+	if !ok {
+		return
+	}
+	if m.heapIndex >= 0 {
+		heap.Remove(q.ready[readyKey(s.Tasks[m.id])], m.heapIndex)
+	}
+	q.queued--
+	if q.queued == 0 {
+		delete(s.Queues, m.queue)
+	}
+}
+
+// Переносит в кучу готовых задачи, которые ждали результата taskID и теперь
+// готовы. Вызывается под s.Mu
+func (s *Store) wakeDependents(taskID string) {
+	for _, id := range s.dependents[taskID] {
+		m, ok := s.meta[id]
+		if !ok || !m.queued || m.heapIndex >= 0 {
+			continue
+		}
+		if task := s.Tasks[id]; s.isTaskReady(task) {
+			s.Queues[m.queue].push(readyKey(task), m)
+		}
+	}
+}
+
+// Куча готовых задач, в которую попадает задача. Десятичные задачи лежат
+// отдельно: иначе задача, которую агент не может взять, закрывала бы от него
+// задачи с той же операцией
+func readyKey(task models.Task) string {
+	if task.Decimal {
+		return task.Operation + " decimal"
+	}
+	return task.Operation
+}
+
+func (q *TaskQueue) push(key string, m *taskMeta) {
+	h, ok := q.ready[key]
+	if !ok {
+		h = &readyHeap{}
+		q.ready[key] = h
+	}
+	heap.Push(h, m)
+}
+
+// Задача, которую очередь выдаст агенту agentID следующей, или nil: лучшая
+// среди вершин куч тех операций, что агент может взять сейчас
+func (s *Store) nextReady(q *TaskQueue, agentID string) *taskMeta {
+	var best *taskMeta
+	for _, h := range q.ready {
+		if len(*h) == 0 {
+			continue
+		}
+		top := (*h)[0]
+		if (best == nil || top.before(best)) && s.canRun(agentID, s.Tasks[top.id]) {
+			best = top
+		}
+	}
+	return best
+}
+
+// Записывает срок аренды выданной задачи. Вызывается под s.Mu
+func (s *Store) addLease(taskID string, until time.Time) {
+	heap.Push(&s.leases, lease{taskID: taskID, until: until})
+}
@@ -0,0 +1,330 @@
+package store
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+)
+
+const (
+	MinPriority = 0
+	MaxPriority = 9
+)
+
+// Как часто ожидающий задачу проверяет, не истекла ли чья-то аренда
+const leaseCheckInterval = time.Second
+
+// Ключ очереди: у каждого владельца отдельная очередь на каждый приоритет
+type QueueKey struct {
+	Owner    string
+	Priority int
+}
+
+// Очередь задач одного владельца с одним приоритетом
+type TaskQueue struct {
+	ready   map[string]*readyHeap // Готовые задачи по операциям, см. readyKey
+	queued  int                   // Сколько задач в очереди, готовых и ждущих зависимости
+	virtual float64               // Виртуальное время очереди: сколько обслуживания она уже получила с учётом веса
+}
+
+// Задача из очереди вместе с её положением, для мониторинга
+type PendingTask struct {
+	models.Task
+	Owner    string `json:"owner"`
+	Priority int    `json:"priority"`
+	Position int    `json:"queue_position,omitempty"` // Место в очереди владельца, 0 - задача уже у агента
+	Blocked  string `json:"blocked,omitempty"`        // Почему готовую задачу некому выдать
+}
+
+// Служебные данные задачи, нужные планировщику. Хранятся, пока выражение
+// не завершено, см. retire
+type taskMeta struct {
+	id           string
+	exprID       int
+	queue        QueueKey
+	critical     time.Duration // Длина критического пути от задачи до корня, включая саму задачу
+	enqueuedAt   time.Time
+	dispatchedAt time.Time // Когда задача выдана агенту, нулевое значение - ещё не выдана
+	leaseUntil   time.Time // До какого момента агент может считать задачу, нулевое значение - без срока
+	finishedAt   time.Time
+	agent        string // Агент, которому выдана задача
+	attempts     int    // Сколько раз задача выдавалась агентам
+	failed       bool
+	queued       bool   // Стоит в очереди: не выдана, не посчитана и не снята
+	seq          uint64 // Порядок постановки в очередь
+	heapIndex    int    // Место в куче готовых задач, -1 - задача не в куче
+}
+
+// Вес очереди в справедливом распределении: чем выше приоритет, тем больше доля агентов
+func weight(priority int) float64 {
+	return float64(priority + 1)
+}
+
+// Возвращает ключ очереди для выражения
+func (s *Store) queueKey(exprID int) QueueKey {
+	expr := s.Expressions[exprID]
+	return QueueKey{Owner: expr.Owner, Priority: expr.Priority}
+}
+
+// Заменяет время выполнения операций; действует для задач, добавленных после вызова
+func (s *Store) SetOperationCosts(costs map[string]time.Duration) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.OperationCosts = costs
+}
+
+// Считает для каждой задачи оставшийся критический путь: её время плюс самый
+// длинный путь среди задач, которые ждут её результат
+func (s *Store) computeCriticalPaths(tasks []models.Task) {
+	parents := make(map[string][]string)
+	for _, task := range tasks {
+		parents[task.Arg1] = append(parents[task.Arg1], task.ID)
+		if task.Arg2 != task.Arg1 {
+			parents[task.Arg2] = append(parents[task.Arg2], task.ID)
+		}
+	}
+
+	// Родители идут раньше детей, поэтому их путь уже посчитан
+	for _, task := range tasks {
+		var longest time.Duration
+		for _, parentID := range parents[task.ID] {
+			if m, ok := s.meta[parentID]; ok && m.critical > longest {
+				longest = m.critical
+			}
+		}
+		s.meta[task.ID].critical = s.OperationCosts[task.Operation] + longest
+	}
+}
+
+// Извлекает следующую готовую задачу. Очередь выбирается взвешенной
+// справедливой очередью: обслуживается та, что получила меньше всего времени
+// агентов с учётом приоритета. Внутри очереди выражения идут по порядку
+// поступления, а в выражении первой выдаётся задача с самым длинным
+// критическим путём. Задача сразу записывается за агентом agentID (если он
+// задан), и выдаются только задачи, которые агент умеет считать
+func (s *Store) GetPendingTask(agentID string) (models.Task, bool) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	return s.pendingTask(agentID)
+}
+
+// Ждёт готовую задачу, пока не отменён ctx. Ожидающих будят новые задачи,
+// результаты (от них зависят другие задачи) и возвращённые в очередь задачи
+func (s *Store) WaitPendingTask(ctx context.Context, agentID string) (models.Task, bool) {
+	tasks := s.WaitLeaseTasks(ctx, agentID, 1)
+	if len(tasks) == 0 {
+		return models.Task{}, false
+	}
+	return tasks[0], true
+}
+
+// Выдаёт до max готовых задач разом, в том же порядке, что и GetPendingTask
+func (s *Store) LeaseTasks(agentID string, max int) []models.Task {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	return s.pendingTasks(agentID, max)
+}
+
+// Ждёт, пока появится хотя бы одна готовая задача, и выдаёт до max задач.
+// Пустой список - ctx отменён раньше
+func (s *Store) WaitLeaseTasks(ctx context.Context, agentID string, max int) []models.Task {
+	for {
+		s.Mu.Lock()
+		tasks := s.pendingTasks(agentID, max)
+		changed := s.changed // Под той же блокировкой, чтобы не пропустить пробуждение
+		s.Mu.Unlock()
+		if len(tasks) > 0 {
+			return tasks
+		}
+
+		timer := time.NewTimer(leaseCheckInterval) // Истечение аренды никого не будит
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Выдаёт до max готовых задач. Вызывается под s.Mu
+func (s *Store) pendingTasks(agentID string, max int) []models.Task {
+	var tasks []models.Task
+	for len(tasks) < max {
+		task, ok := s.pendingTask(agentID)
+		if !ok {
+			break
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// Будит всех, кто ждёт задачи в WaitLeaseTasks. Вызывается под s.Mu
+func (s *Store) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Выдаёт следующую готовую задачу, см. GetPendingTask. Вызывается под s.Mu
+func (s *Store) pendingTask(agentID string) (models.Task, bool) {
+	s.expireExpressions(time.Now())
+	s.reclaimExpiredLeases(time.Now())
+
+	var best *taskMeta
+	var bestQueue *TaskQueue
+	var bestKey QueueKey
+	bestStart := 0.0
+	for key, q := range s.Queues {
+		m := s.nextReady(q, agentID)
+		if m == nil {
+			continue
+		}
+		start := q.virtual
+		if start < s.virtualClock {
+			start = s.virtualClock // Очередь простаивала без готовых задач - кредит не копится
+		}
+		if best == nil || start < bestStart || start == bestStart && m.before(best) {
+			best, bestQueue, bestKey, bestStart = m, q, key, start
+		}
+	}
+	if best == nil {
+		return models.Task{}, false // Готовых задач нет
+	}
+
+	taskID := best.id
+	task := s.Tasks[taskID]
+	s.dequeue(best)
+	s.virtualClock = bestStart
+	bestQueue.virtual = bestStart + s.serviceCost(task)/weight(bestKey.Priority)
+	if agentID != "" {
+		s.assignTask(agentID, taskID, time.Now())
+	}
+	best.dispatchedAt = time.Now()
+	best.attempts++
+	if s.LeaseTimeout > 0 {
+		best.leaseUntil = best.dispatchedAt.Add(s.LeaseTimeout)
+		task.LeaseMS = s.LeaseTimeout.Milliseconds()
+		s.addLease(taskID, best.leaseUntil)
+	}
+	if expr, exists := s.Expressions[best.exprID]; exists && expr.StartedAt == nil {
+		if expr.Status == models.StatusQueued {
+			setStatus(&expr, models.StatusRunning, "scheduler", "task "+taskID, best.dispatchedAt)
+		} else {
+			recordEvent(&expr, EventStarted, "scheduler", "task "+taskID, best.dispatchedAt)
+		}
+		s.Expressions[best.exprID] = expr
+	}
+	log.Printf("Задача %s готова и выдана из очереди %+v: %+v", task.ID, bestKey, task)
+	return task, true
+}
+
+// Возвращает в очередь задачи, агенты которых не прислали результат до конца
+// аренды. Разбирает только истёкшие аренды. Вызывается под s.Mu
+func (s *Store) reclaimExpiredLeases(now time.Time) {
+	for len(s.leases) > 0 && !now.Before(s.leases[0].until) {
+		l := heap.Pop(&s.leases).(lease)
+		m, ok := s.meta[l.taskID]
+		if !ok || !m.leaseUntil.Equal(l.until) || !m.finishedAt.IsZero() || s.Tasks[l.taskID].Completed {
+			continue // Задача уже вернулась, посчитана или выдана заново
+		}
+		log.Printf("Аренда задачи %s агентом %s истекла, задача снова в очереди", l.taskID, m.agent)
+		s.dropAgentTask(m.agent, l.taskID)
+		s.requeue(l.taskID, m, "scheduler", "lease of task "+l.taskID+" expired", now)
+		m.leaseUntil = time.Time{}
+	}
+}
+
+// Время, которое задача займёт у агента; не меньше миллисекунды, чтобы
+// бесплатные операции тоже учитывались в справедливом распределении
+func (s *Store) serviceCost(task models.Task) float64 {
+	cost := float64(s.OperationCosts[task.Operation]) / float64(time.Millisecond)
+	if cost < 1 {
+		cost = 1
+	}
+	return cost
+}
+
+// Возвращает незавершённые задачи с их очередью и местом в ней
+func (s *Store) GetPendingTasks() []PendingTask {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	queued := make(map[QueueKey][]*taskMeta)
+	for _, ids := range s.exprTasks {
+		for _, taskID := range ids {
+			if m := s.meta[taskID]; m.queued {
+				queued[m.queue] = append(queued[m.queue], m)
+			}
+		}
+	}
+	positions := make(map[string]int)
+	for _, ordered := range queued {
+		sort.Slice(ordered, func(i, j int) bool { return ordered[i].before(ordered[j]) })
+		for i, m := range ordered {
+			positions[m.id] = i + 1
+		}
+	}
+
+	now := time.Now()
+	var tasks []PendingTask
+	for id, ids := range s.exprTasks {
+		if s.Expressions[id].Status.IsFinal() {
+			continue // Выражение отменено или уже не посчитать
+		}
+		for _, taskID := range ids {
+			task, m := s.Tasks[taskID], s.meta[taskID]
+			if task.Completed { // Показываем только незавершённые задачи
+				continue
+			}
+			pending := PendingTask{Task: task, Position: positions[taskID], Owner: m.queue.Owner, Priority: m.queue.Priority}
+			if pending.Position > 0 && s.isTaskReady(task) {
+				pending.Blocked = s.blockedReason(task, now)
+			}
+			tasks = append(tasks, pending)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].ID < tasks[j].ID
+	})
+	return tasks
+}
+
+// Оценивает время завершения выражения по критическому пути незавершённых
+// задач. Для уже выданных задач учитывается время, прошедшее с выдачи
+func (s *Store) PredictCompletion(id int) (time.Time, bool) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	now := time.Now()
+	var remaining time.Duration
+	found := false
+	for _, taskID := range s.exprTasks[id] {
+		m := s.meta[taskID]
+		if s.Tasks[taskID].Completed {
+			continue
+		}
+		found = true
+		left := m.critical
+		if !m.dispatchedAt.IsZero() {
+			elapsed := now.Sub(m.dispatchedAt)
+			if cost := s.OperationCosts[s.Tasks[taskID].Operation]; elapsed > cost {
+				elapsed = cost
+			}
+			left -= elapsed
+		}
+		if left > remaining {
+			remaining = left
+		}
+	}
+	if !found {
+		return time.Time{}, false
+	}
+	return now.Add(remaining), true
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/parser"
+)
+
+// Снимок хранилища: пишется при остановке оркестратора и читается при запуске
+type Snapshot struct {
+	SavedAt     time.Time           `json:"saved_at"`
+	Expressions []models.Expression `json:"expressions"`
+	Tasks       []models.Task       `json:"tasks"`
+}
+
+// Сохраняет выражения и задачи в файл. Файл пишется через временный, чтобы
+// прерванная запись не испортила прежний снимок
+func (s *Store) Save(path string) error {
+	s.Mu.Lock()
+	snapshot := Snapshot{SavedAt: time.Now()}
+	for _, expr := range s.Expressions {
+		snapshot.Expressions = append(snapshot.Expressions, expr)
+	}
+	for _, task := range s.Tasks {
+		snapshot.Tasks = append(snapshot.Tasks, task)
+	}
+	for _, id := range s.archived { // Задачи завершённых выражений уже не в Tasks
+		for _, info := range s.archive[id] {
+			snapshot.Tasks = append(snapshot.Tasks, info.Task)
+		}
+	}
+	s.Mu.Unlock()
+
+	sort.Slice(snapshot.Expressions, func(i, j int) bool { return snapshot.Expressions[i].Id < snapshot.Expressions[j].Id })
+	sort.Slice(snapshot.Tasks, func(i, j int) bool { return taskIndexLess(snapshot.Tasks[i].ID, snapshot.Tasks[j].ID) })
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Загружает снимок из файла. Незавершённые задачи незавершённых выражений
+// снова ставятся в очередь, в том числе те, что были у агентов. Возвращает
+// наибольший ID выражения; если файла нет, хранилище остаётся пустым
+func (s *Store) Restore(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	maxID := 0
+	for _, expr := range snapshot.Expressions {
+		s.Expressions[expr.Id] = expr
+		maxID = max(maxID, expr.Id)
+	}
+
+	// Задачи выражения от корня к листьям, как их отдаёт BuildTasks: у родителя номер больше
+	byExpr := make(map[int][]models.Task)
+	for _, task := range snapshot.Tasks {
+		id := exprIDFromTask(task.ID)
+		byExpr[id] = append(byExpr[id], task)
+	}
+	ids := make([]int, 0, len(byExpr))
+	for id := range byExpr {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids) // Завершённые выражения попадают в архив по порядку, старые вытесняются первыми
+	queued := 0
+	for _, id := range ids {
+		tasks := byExpr[id]
+		sort.Slice(tasks, func(i, j int) bool { return taskIndexLess(tasks[j].ID, tasks[i].ID) })
+		s.registerTasks(id, tasks)
+		if s.Expressions[id].Status.IsFinal() {
+			s.retire(id)
+			continue
+		}
+		for i := len(tasks) - 1; i >= 0; i-- {
+			if task := tasks[i]; !task.Completed && task.Operation != parser.Conditional {
+				s.enqueue(s.meta[task.ID])
+				queued++
+			}
+		}
+	}
+	log.Printf("Состояние восстановлено из %s (сохранено %s): выражений %d, задач в очереди %d",
+		path, snapshot.SavedAt.Format(time.RFC3339), len(snapshot.Expressions), queued)
+	return maxID, nil
+}
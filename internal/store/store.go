@@ -1,6 +1,7 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"github.com/NieR8/myProject/models"
 	"github.com/NieR8/myProject/pkg/parser"
@@ -8,36 +9,102 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+var ErrFinished = errors.New("expression already finished")
+
 type Store struct {
-	Mu           sync.Mutex
-	Expressions  map[int]models.Expression
-	Tasks        map[string]models.Task
-	PendingTasks chan models.Task // Очередь задач, ожидающих выполнения агентом
+	Mu             sync.Mutex
+	Expressions    map[int]models.Expression
+	Tasks          map[string]models.Task
+	Queues         map[QueueKey]*TaskQueue  // Очереди задач, ожидающих выполнения агентом, по владельцу и приоритету
+	OperationCosts map[string]time.Duration // Время выполнения операций, по нему считается критический путь
+	IdempotencyTTL time.Duration            // Сколько хранятся ключи идемпотентности
+	LeaseTimeout   time.Duration            // Сколько агент может считать задачу, потом она выдаётся снова; 0 - без ограничения
+	MaxTasks       int                      // Сколько задач может породить выражение вместе с ветками условий; 0 - без ограничения
+	ExprTimeout    time.Duration            // Сколько выражение может считаться с момента приёма, потом оно timed_out; 0 - без ограничения
+	ArchiveSize    int                      // Для скольких последних завершённых выражений хранятся задачи; 0 - для всех
+	OnTaskDone     func(task models.Task)   // Вызывается под блокировкой, когда агент прислал результат задачи
+	meta           map[string]*taskMeta
+	exprTasks      map[int][]string    // ID задач незавершённых выражений
+	dependents     map[string][]string // Задачи, ждущие результат задачи
+	archive        map[int][]TaskInfo  // Задачи завершённых выражений, см. retire
+	archived       []int               // Выражения из archive в порядке завершения, первым вытесняется старейшее
+	leases         leaseHeap           // Сроки аренды выданных задач
+	deadlines      deadlineHeap        // Сроки незавершённых выражений, см. ExprTimeout
+	seq            uint64              // Счётчик постановок в очередь
+	virtualClock   float64             // Виртуальное время справедливой очереди
+	idempotency    map[string]*IdempotentResponse
+	idemExpiry     idempotencyHeap // Сроки ключей идемпотентности, см. expireIdempotencyKeys
+	agents         map[string]*models.AgentInfo
+	changed        chan struct{} // Закрывается, когда может появиться готовая задача
 }
 
 func NewStore() *Store {
 	return &Store{
-		Expressions:  make(map[int]models.Expression),
-		Tasks:        make(map[string]models.Task),
-		PendingTasks: make(chan models.Task, 100),
+		Expressions:    make(map[int]models.Expression),
+		Tasks:          make(map[string]models.Task),
+		Queues:         make(map[QueueKey]*TaskQueue),
+		OperationCosts: make(map[string]time.Duration),
+		IdempotencyTTL: 24 * time.Hour,
+		ArchiveSize:    1000,
+		meta:           make(map[string]*taskMeta),
+		exprTasks:      make(map[int][]string),
+		dependents:     make(map[string][]string),
+		archive:        make(map[int][]TaskInfo),
+		idempotency:    make(map[string]*IdempotentResponse),
+		agents:         make(map[string]*models.AgentInfo),
+		changed:        make(chan struct{}),
 	}
 }
 
-// Добавляет новое выражение в хранилище
-func (s *Store) AddExpression(expr models.Expression) {
+// Добавляет новое выражение в хранилище или заменяет уже добавленное.
+// История и метки времени хранятся в Store: новое выражение получает событие
+// submitted от владельца, смена статуса записывается как переход от оркестратора.
+// Недопустимая смена статуса отклоняется с ErrInvalidTransition
+func (s *Store) AddExpression(expr models.Expression) error {
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
+	now := time.Now()
+	prev, exists := s.Expressions[expr.Id]
+	if !exists {
+		if !expr.Status.Valid() {
+			return fmt.Errorf("%w: expression %d has unknown status %q", ErrInvalidTransition, expr.Id, expr.Status)
+		}
+		expr.Events = nil
+		recordEvent(&expr, EventSubmitted, expr.Owner, "", now)
+		if expr.Status != models.StatusPending {
+			recordEvent(&expr, statusEvent(expr.Status), "orchestrator", expr.Error, now)
+		}
+	} else {
+		status := expr.Status
+		expr.Status = prev.Status
+		expr.CreatedAt, expr.StartedAt, expr.Events = prev.CreatedAt, prev.StartedAt, prev.Events
+		expr.CompletedAt, expr.FailedAt, expr.CancelledAt = prev.CompletedAt, prev.FailedAt, prev.CancelledAt
+		if status != prev.Status {
+			if err := setStatus(&expr, status, "orchestrator", expr.Error, now); err != nil {
+				return err
+			}
+		}
+	}
 	s.Expressions[expr.Id] = expr
+	if expr.Status.IsFinal() {
+		s.retire(expr.Id)
+	}
 	log.Printf("Добавлено выражение %d: %+v", expr.Id, expr)
+	return nil
 }
 
 // Возвращает выражение по его id
 func (s *Store) GetExpression(id int) (models.Expression, bool) {
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
+	s.expireExpressions(time.Now())
 	expr, exists := s.Expressions[id]
+	if exists {
+		expr = s.view(expr, time.Now())
+	}
 	log.Printf("Запрошено выражение %d: найдено=%v, %+v", id, exists, expr)
 	return expr, exists
 }
@@ -46,8 +113,11 @@ func (s *Store) GetExpression(id int) (models.Expression, bool) {
 func (s *Store) GetAllExpressions() []models.Expression {
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
+	now := time.Now()
+	s.expireExpressions(now)
 	var expressions []models.Expression
 	for _, expr := range s.Expressions {
+		expr = s.view(expr, now)
 		expressions = append(expressions, expr)
 	}
 	log.Printf("Возвращено %d выражений", len(expressions))
@@ -57,10 +127,63 @@ func (s *Store) GetAllExpressions() []models.Expression {
 // Добавляет задачу в хранилище и очередь
 func (s *Store) AddTask(task models.Task) {
 	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	exprID := exprIDFromTask(task.ID)
+	m := s.addTask(task, exprID, s.queueKey(exprID))
+	if task.Operation != parser.Conditional { // Условия разрешает само хранилище, см. resolveConditionals
+		s.enqueue(m)
+	}
+}
+
+// Добавляет все задачи выражения разом и считает для них критический путь.
+// Задачи ожидаются в порядке BuildTasks: корень первым, родители раньше детей
+func (s *Store) AddTasks(exprID int, tasks []models.Task) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	s.registerTasks(exprID, tasks)
+	s.enqueueTasks(tasks)
+}
+
+// Записывает задачи выражения в хранилище и считает критический путь, но не
+// ставит их в очередь. Вызывается под s.Mu
+func (s *Store) registerTasks(exprID int, tasks []models.Task) {
+	key := s.queueKey(exprID)
+	for i := len(tasks) - 1; i >= 0; i-- {
+		s.addTask(tasks[i], exprID, key)
+	}
+	s.computeCriticalPaths(tasks)
+	s.scheduleTimeout(exprID)
+}
+
+// Ставит в очередь задачи в порядке BuildTasks, начиная с листьев; задачи
+// условий остаются вне очереди. Вызывается под s.Mu после registerTasks
+func (s *Store) enqueueTasks(tasks []models.Task) {
+	for i := len(tasks) - 1; i >= 0; i-- {
+		if tasks[i].Operation != parser.Conditional {
+			s.enqueue(s.meta[tasks[i].ID])
+		}
+	}
+}
+
+// Записывает задачу в хранилище и запоминает, от каких задач она зависит
+func (s *Store) addTask(task models.Task, exprID int, key QueueKey) *taskMeta {
+	if s.Expressions[exprID].Decimal {
+		task.Decimal = true
+	}
 	s.Tasks[task.ID] = task
+	m := &taskMeta{id: task.ID, exprID: exprID, queue: key, critical: s.OperationCosts[task.Operation], enqueuedAt: time.Now(), heapIndex: -1}
+	s.meta[task.ID] = m
+	s.exprTasks[exprID] = append(s.exprTasks[exprID], task.ID)
+	for _, arg := range []string{task.Arg1, task.Arg2} {
+		if arg != "" && !isNumeric(arg) {
+			s.dependents[arg] = append(s.dependents[arg], task.ID)
+		}
+		if task.Arg2 == task.Arg1 {
+			break
+		}
+	}
 	log.Printf("Задача %s добавлена в Tasks: %+v, всего задач: %d", task.ID, task, len(s.Tasks))
-	s.PendingTasks <- task
-	s.Mu.Unlock()
+	return m
 }
 
 // Обновляет задачу результатом от агента и проверяет завершение выражения
@@ -69,38 +192,58 @@ func (s *Store) UpdateTask(result models.Result) bool {
 	defer s.Mu.Unlock()
 
 	task, exists := s.Tasks[result.TaskID]
+	if !exists && s.retired(result.TaskID) {
+		log.Printf("Выражение задачи %s уже завершено, результат не нужен", result.TaskID)
+		return true
+	}
 	if !exists {
-		log.Printf("Ошибка: задача %s не найдена в Tasks: %+v", result.TaskID, s.Tasks)
+		log.Printf("Ошибка: задача %s не найдена в Tasks", result.TaskID)
 		return false
 	}
 
+	if result.Error != "" {
+		return s.failTask(task, result.Error)
+	}
+
 	log.Printf("Обновление задачи %s: старое значение %+v, новый результат %f", result.TaskID, task, result.Value)
 	task.Result = result.Value
 	task.Completed = true
 	s.Tasks[result.TaskID] = task
+	s.wakeDependents(task.ID)
+	s.notify() // Задачи, ждавшие этот результат, стали готовы
+	actor := agentActor("")
+	if m, ok := s.meta[task.ID]; ok {
+		m.finishedAt = time.Now()
+		actor = agentActor(m.agent)
+	}
 	log.Printf("Задача %s обновлена: %+v", result.TaskID, task)
+	if s.OnTaskDone != nil {
+		s.OnTaskDone(task)
+	}
 
-	parts := strings.Split(result.TaskID, "-")
-	if len(parts) < 3 {
+	id := exprIDFromTask(result.TaskID)
+	if id < 0 {
 		log.Printf("Ошибка: неверный формат TaskID %s", result.TaskID)
 		return false
 	}
-	exprIDStr := parts[2]
-	id, err := strconv.Atoi(exprIDStr)
-	if err != nil {
-		log.Printf("Ошибка разбора exprID из %s: %v", result.TaskID, err)
-		return false
-	}
 
 	expr, exists := s.Expressions[id]
 	if !exists {
 		log.Printf("Выражение %d не найдено для задачи %s", id, result.TaskID)
 		return false
 	}
+	if expr.Status.IsFinal() {
+		log.Printf("Выражение %d уже в статусе %s, результат задачи %s не нужен", id, expr.Status, result.TaskID)
+		return true
+	}
+	if !s.resolveConditionals(id) {
+		return true
+	}
+	expr = s.Expressions[id] // Ветка условия могла дописать задачи в дерево
 
 	allCompleted := true
-	for _, t := range s.Tasks {
-		if strings.Contains(t.ID, fmt.Sprintf("expr-%d", id)) && !t.Completed {
+	for _, taskID := range s.exprTasks[id] {
+		if t := s.Tasks[taskID]; !t.Completed {
 			log.Printf("Задача %s для выражения %d ещё не завершена: %+v", t.ID, id, t)
 			allCompleted = false
 			break
@@ -111,80 +254,145 @@ func (s *Store) UpdateTask(result models.Result) bool {
 		log.Printf("Все задачи для выражения %d завершены, пересчитываем результат", id)
 		finalResult, err := s.calculateExpression(expr)
 		if err != nil {
-			expr.Status = 3
+			expr.Error = err.Error()
+			expr.ErrorDetails = &models.StatusError{Code: "evaluation_error", Message: expr.Error}
+			if err := setStatus(&expr, models.StatusFailed, "orchestrator", expr.Error, time.Now()); err != nil {
+				log.Printf("Выражение %d: %v", id, err)
+				return true
+			}
 			s.Expressions[id] = expr
+			s.retire(id)
 			log.Printf("Ошибка при вычислении выражения %d: %v", id, err)
 			return true
 		}
 		expr.Result = finalResult
-		expr.Status = 0
+		if err := setStatus(&expr, models.StatusCompleted, actor, "task "+task.ID, time.Now()); err != nil {
+			log.Printf("Выражение %d: %v", id, err)
+			return true
+		}
 		s.Expressions[id] = expr
+		s.retire(id)
 		log.Printf("Выражение %d завершено: %+v", id, expr)
 	}
 
 	return true
 }
 
-// Вычисляет итоговый результат выражения по его дереву
-func (s *Store) calculateExpression(expr models.Expression) (float64, error) {
-	return s.evaluateNode(expr.Node)
+// Помечает выражение проваленным, если агент не смог посчитать его задачу
+func (s *Store) failTask(task models.Task, reason string) bool {
+	actor := agentActor("")
+	if m, ok := s.meta[task.ID]; ok {
+		m.failed = true
+		m.finishedAt = time.Now()
+		actor = agentActor(m.agent)
+	}
+	id := exprIDFromTask(task.ID)
+	expr, exists := s.Expressions[id]
+	if !exists {
+		log.Printf("Выражение %d не найдено для задачи %s", id, task.ID)
+		return false
+	}
+	if !expr.Status.IsFinal() {
+		expr.Error = fmt.Sprintf("task %s: %s", task.ID, reason)
+		expr.ErrorDetails = &models.StatusError{Code: "task_failed", Message: reason, TaskID: task.ID}
+		if err := setStatus(&expr, models.StatusFailed, actor, expr.Error, time.Now()); err != nil {
+			log.Printf("Выражение %d: %v", id, err)
+			return true
+		}
+		s.Expressions[id] = expr
+		s.retire(id)
+	}
+	log.Printf("Задача %s не посчитана: %s, выражение %d в статусе %s", task.ID, reason, id, expr.Status)
+	return true
 }
 
-// Рекурсивно вычисляет значение узла дерева
-func (s *Store) evaluateNode(node *models.Node) (float64, error) {
-	if node == nil {
-		return 0, fmt.Errorf("nil node")
-	}
+// Отменяет выражение: его задачи убираются из очередей, а результаты уже
+// выданных агентам задач будут проигнорированы. Возвращает false, если
+// выражения нет; ErrFinished, если оно уже посчитано. actor попадает в историю
+func (s *Store) CancelExpression(id int, actor string) (models.Expression, bool, error) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
 
-	if !parser.IsOperator(node.Value) {
-		return strconv.ParseFloat(node.Value, 64)
+	expr, exists := s.Expressions[id]
+	if !exists {
+		return models.Expression{}, false, nil
+	}
+	if expr.Status.IsFinal() {
+		return expr, true, ErrFinished
 	}
 
-	leftVal, err := s.evaluateNode(node.Left)
-	if err != nil {
-		return 0, err
+	now := time.Now()
+	if err := setStatus(&expr, models.StatusCancelled, actor, "", now); err != nil {
+		return expr, true, err
 	}
+	expr.ErrorDetails = &models.StatusError{Code: "cancelled", Message: "cancelled by request", Actor: actor}
+	s.Expressions[id] = expr
+	s.retire(id)
+	log.Printf("Выражение %d отменено", id)
+	return s.view(expr, now), true, nil
+}
 
-	rightVal, err := s.evaluateNode(node.Right)
-	if err != nil {
-		return 0, err
-	}
-
-	switch node.Value {
-	case "+":
-		return leftVal + rightVal, nil
-	case "-":
-		return leftVal - rightVal, nil
-	case "*":
-		return leftVal * rightVal, nil
-	case "/":
-		if rightVal == 0 {
-			return 0, fmt.Errorf("division by zero")
+// Убирает из очередей ещё не выданные задачи завершённого выражения и
+// забывает его задачи и их служебные данные. Прогресс запоминается в
+// выражении, а состояние задач для GetExpressionTasks - в архиве, из которого
+// старые выражения вытесняются по ArchiveSize. Результат, который агент
+// пришлёт за такой задачей позже, принимается и отбрасывается, см. Retired.
+// Вызывается под s.Mu
+func (s *Store) retire(id int) {
+	ids, ok := s.exprTasks[id]
+	if !ok {
+		return
+	}
+	expr := s.Expressions[id]
+	expr.Progress = s.taskProgress(id)
+	s.Expressions[id] = expr
+	s.archiveTasks(id, s.expressionTasks(id, expr, time.Now()))
+	for _, taskID := range ids {
+		if m, ok := s.meta[taskID]; ok {
+			s.dequeue(m)
+			if m.agent != "" {
+				s.dropAgentTask(m.agent, taskID)
+			}
 		}
-		return leftVal / rightVal, nil
-	default:
-		return 0, fmt.Errorf("unsupported operation: %s", node.Value)
+		delete(s.meta, taskID)
+		delete(s.dependents, taskID)
+		delete(s.Tasks, taskID)
+	}
+	delete(s.exprTasks, id)
+}
+
+// Кладёт задачи завершённого выражения в архив и вытесняет старейшие
+// выражения сверх ArchiveSize. Вызывается под s.Mu
+func (s *Store) archiveTasks(id int, tasks []TaskInfo) {
+	s.archive[id] = tasks
+	s.archived = append(s.archived, id)
+	for s.ArchiveSize > 0 && len(s.archived) > s.ArchiveSize {
+		delete(s.archive, s.archived[0])
+		s.archived = s.archived[1:]
 	}
 }
 
-// Извлекает следующую готовую задачу из очереди
-func (s *Store) GetPendingTask() (models.Task, bool) {
+// Относится ли задача к завершённому выражению: такие задачи удаляются из
+// Tasks, а их результаты уже не нужны
+func (s *Store) Retired(taskID string) bool {
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
+	return s.retired(taskID)
+}
 
-	for i := 0; i < cap(s.PendingTasks); i++ {
-		select {
-		case task := <-s.PendingTasks:
-			if s.isTaskReady(task) {
-				log.Printf("Задача %s готова и выдана: %+v", task.ID, task)
-				return task, true // Выдали задачу
-			}
-			s.PendingTasks <- task // Задача еще не готова, возвращаем ее в очередь
-		default:
-			return models.Task{}, false // Очередь задач пуста
-		}
-	}
-	return models.Task{}, false
+func (s *Store) retired(taskID string) bool {
+	expr, exists := s.Expressions[exprIDFromTask(taskID)]
+	return exists && expr.Status.IsFinal()
+}
+
+// Вычисляет итоговый результат выражения по его дереву
+func (s *Store) calculateExpression(expr models.Expression) (float64, error) {
+	return s.evaluateNode(expr.Node)
+}
+
+// Рекурсивно вычисляет значение узла дерева
+func (s *Store) evaluateNode(node *models.Node) (float64, error) {
+	return parser.Evaluate(node)
 }
 
 func (s *Store) isTaskReady(task models.Task) bool {
@@ -210,3 +418,16 @@ func isNumeric(arg string) bool {
 	_, err := strconv.ParseFloat(arg, 64)
 	return err == nil
 }
+
+// Извлекает ID выражения из ID задачи вида task-expr-N-i, -1 если формат неверный
+func exprIDFromTask(taskID string) int {
+	parts := strings.Split(taskID, "-")
+	if len(parts) < 3 {
+		return -1
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return -1
+	}
+	return id
+}
@@ -1,13 +1,20 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/parser"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAddAndGetExpression(t *testing.T) {
 	store := NewStore()
-	expr := models.Expression{Name: "2+3", Status: 1, Id: 1}
+	expr := models.Expression{Name: "2+3", Status: models.StatusRunning, Id: 1}
 	store.AddExpression(expr)
 
 	got, exists := store.GetExpression(1)
@@ -23,7 +30,7 @@ func TestUpdateTask(t *testing.T) {
 	store := NewStore()
 	expr := models.Expression{
 		Name:   "2+3",
-		Status: 1,
+		Status: models.StatusRunning,
 		Id:     1,
 		Node:   &models.Node{Value: "+", Left: &models.Node{Value: "2"}, Right: &models.Node{Value: "3"}},
 	}
@@ -43,7 +50,671 @@ func TestUpdateTask(t *testing.T) {
 	}
 
 	updatedExpr, _ := store.GetExpression(1)
-	if updatedExpr.Status != 0 || updatedExpr.Result != 5 {
+	if updatedExpr.Status != models.StatusCompleted || updatedExpr.Result != 5 {
 		t.Errorf("Expression not completed: %+v", updatedExpr)
 	}
 }
+
+func TestGetPendingTaskCriticalPath(t *testing.T) {
+	store := NewStore()
+	store.OperationCosts = map[string]time.Duration{"+": 10 * time.Millisecond, "/": 250 * time.Millisecond}
+
+	// (1+2) + (3/4): деление дороже, поэтому должно уйти агенту первым
+	tasks := []models.Task{
+		{ID: "task-expr-1-2", Arg1: "task-expr-1-0", Arg2: "task-expr-1-1", Operation: "+"},
+		{ID: "task-expr-1-1", Arg1: "3", Arg2: "4", Operation: "/"},
+		{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"},
+	}
+	store.AddTasks(1, tasks)
+	store.AddTasks(2, []models.Task{{ID: "task-expr-2-0", Arg1: "5", Arg2: "6", Operation: "/"}})
+
+	for _, want := range []string{"task-expr-1-1", "task-expr-1-0", "task-expr-2-0"} {
+		task, ok := store.GetPendingTask("")
+		if !ok || task.ID != want {
+			t.Fatalf("GetPendingTask() = %s, %v, want %s", task.ID, ok, want)
+		}
+	}
+	if task, ok := store.GetPendingTask(""); ok {
+		t.Errorf("GetPendingTask() returned %s before its dependencies completed", task.ID)
+	}
+
+	finish, ok := store.PredictCompletion(1)
+	if !ok {
+		t.Fatalf("PredictCompletion(1) expected a prediction")
+	}
+	if remaining := time.Until(finish); remaining <= 0 || remaining > 260*time.Millisecond {
+		t.Errorf("PredictCompletion(1) remaining = %v, want within critical path 260ms", remaining)
+	}
+}
+
+func TestGetPendingTaskFairShare(t *testing.T) {
+	store := NewStore()
+	store.OperationCosts = map[string]time.Duration{"+": 100 * time.Millisecond}
+
+	addExpr := func(id int, owner string, priority int) {
+		store.AddExpression(models.Expression{Id: id, Status: models.StatusQueued, Owner: owner, Priority: priority})
+		taskID := fmt.Sprintf("task-expr-%d-0", id)
+		store.AddTasks(id, []models.Task{{ID: taskID, Arg1: "1", Arg2: "2", Operation: "+"}})
+	}
+	for id := 1; id <= 10; id++ {
+		addExpr(id, "batch", 0)
+	}
+	addExpr(11, "small", 0)
+	addExpr(12, "urgent", 9)
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		task, ok := store.GetPendingTask("")
+		if !ok {
+			t.Fatalf("GetPendingTask() returned nothing on step %d", i)
+		}
+		order = append(order, task.ID)
+	}
+	want := []string{"task-expr-1-0", "task-expr-11-0", "task-expr-12-0", "task-expr-2-0"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("dispatch order = %v, want %v: small and urgent users must not wait for the whole batch", order, want)
+	}
+
+	positions := map[string]int{}
+	for _, task := range store.GetPendingTasks() {
+		positions[task.ID] = task.Position
+	}
+	if positions["task-expr-1-0"] != 0 || positions["task-expr-3-0"] != 1 {
+		t.Errorf("queue positions = %v, want dispatched task without position and next batch task first", positions)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	store := NewStore()
+	store.IdempotencyTTL = time.Minute
+	now := time.Now()
+
+	if _, reserved := store.ReserveIdempotencyKey("key", "body-1", now); !reserved {
+		t.Fatalf("first request with key was not reserved")
+	}
+	if saved, reserved := store.ReserveIdempotencyKey("key", "body-1", now); reserved || !saved.Pending {
+		t.Errorf("concurrent request = %+v, %v, want pending entry", saved, reserved)
+	}
+
+	store.CompleteIdempotencyKey("key", 7, 201, []byte(`{"id":7}`))
+	saved, reserved := store.ReserveIdempotencyKey("key", "body-1", now.Add(time.Second))
+	if reserved || saved.ExprID != 7 || saved.StatusCode != 201 || saved.Fingerprint != "body-1" {
+		t.Errorf("replay = %+v, %v, want saved response for expression 7", saved, reserved)
+	}
+
+	if _, reserved := store.ReserveIdempotencyKey("key", "body-2", now.Add(2*time.Minute)); !reserved {
+		t.Errorf("expired key was not reserved again")
+	}
+}
+
+func TestIdempotencyKeysExpireOldestFirst(t *testing.T) {
+	store := NewStore()
+	store.IdempotencyTTL = time.Minute
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		store.ReserveIdempotencyKey(fmt.Sprintf("old-%d", i), "body", now.Add(time.Duration(i)*time.Millisecond))
+	}
+	store.ReserveIdempotencyKey("released", "body", now)
+	store.ReleaseIdempotencyKey("released")
+	store.ReserveIdempotencyKey("released", "body-2", now.Add(30*time.Second)) // Старая запись о ключе не должна его удалить
+	store.ReserveIdempotencyKey("fresh", "body", now.Add(50*time.Second))
+
+	// Через минуту с небольшим устарели только первые ключи
+	store.ReserveIdempotencyKey("trigger", "body", now.Add(time.Minute+50*time.Millisecond))
+	store.Mu.Lock()
+	remaining := len(store.idempotency)
+	_, released := store.idempotency["released"]
+	store.Mu.Unlock()
+	if remaining != 50+3 || !released {
+		t.Errorf("%d keys left (released kept: %v), want 53 with released", remaining, released)
+	}
+
+	store.ReserveIdempotencyKey("trigger-2", "body", now.Add(2*time.Minute))
+	store.Mu.Lock()
+	defer store.Mu.Unlock()
+	for _, key := range []string{"old-99", "released", "fresh"} {
+		if _, exists := store.idempotency[key]; exists {
+			t.Errorf("key %s was not expired", key)
+		}
+	}
+	if len(store.idemExpiry) != 2 {
+		t.Errorf("%d entries left in the expiry heap, want 2", len(store.idemExpiry))
+	}
+}
+
+func TestGetExpressionTasks(t *testing.T) {
+	store := NewStore()
+	store.AddExpression(models.Expression{Name: "(1+2)*4", Status: models.StatusQueued, Id: 1})
+	store.AddTasks(1, []models.Task{
+		{ID: "task-expr-1-1", Arg1: "task-expr-1-0", Arg2: "4", Operation: "*"},
+		{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"},
+	})
+
+	task, _ := store.GetPendingTask("")
+	store.AgentTookTask("agent-1", task.ID)
+	store.UpdateTask(models.Result{TaskID: task.ID, Value: 3})
+
+	tasks, exists := store.GetExpressionTasks(1)
+	if !exists || len(tasks) != 2 {
+		t.Fatalf("GetExpressionTasks(1) = %+v, %v", tasks, exists)
+	}
+	sum, product := tasks[0], tasks[1]
+	if sum.State != TaskDone || sum.Agent != "agent-1" || sum.Attempts != 1 || sum.FinishedAt == nil {
+		t.Errorf("sum task = %+v", sum)
+	}
+	if product.State != TaskReady || product.Value1 == nil || *product.Value1 != 3 || *product.Value2 != 4 {
+		t.Errorf("product task = %+v", product)
+	}
+	if expr, _ := store.GetExpression(1); expr.Progress != 50 {
+		t.Errorf("progress = %v, want 50", expr.Progress)
+	}
+}
+
+func TestExpressionHistory(t *testing.T) {
+	store := NewStore()
+	expr := models.Expression{Name: "1+2", Status: models.StatusPending, Id: 1, Owner: "alice",
+		Node: &models.Node{Value: "+", Left: &models.Node{Value: "1"}, Right: &models.Node{Value: "2"}}}
+	store.AddExpression(expr)
+	expr.Status = models.StatusQueued
+	if err := store.AddExpression(expr); err != nil {
+		t.Fatal(err)
+	}
+	store.AddTasks(1, []models.Task{{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"}})
+
+	task, _ := store.GetPendingTask("")
+	store.AgentTookTask("agent-1", task.ID)
+	store.UpdateTask(models.Result{TaskID: task.ID, Value: 3})
+
+	got, _ := store.GetExpression(1)
+	var types []string
+	for _, event := range got.Events {
+		types = append(types, event.Type)
+	}
+	want := []string{EventSubmitted, EventQueued, EventStarted, EventCompleted}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Fatalf("events = %v, want %v", types, want)
+	}
+	if got.Status != models.StatusCompleted || got.Events[2].Status != models.StatusRunning {
+		t.Errorf("status = %s, after start %s", got.Status, got.Events[2].Status)
+	}
+	if got.Events[0].Actor != "alice" || got.Events[3].Actor != "agent agent-1" {
+		t.Errorf("actors = %q, %q", got.Events[0].Actor, got.Events[3].Actor)
+	}
+	if got.CreatedAt.IsZero() || got.StartedAt == nil || got.CompletedAt == nil || got.FailedAt != nil {
+		t.Errorf("timestamps = %v %v %v %v", got.CreatedAt, got.StartedAt, got.CompletedAt, got.FailedAt)
+	}
+	if got.DurationMS != got.CompletedAt.Sub(got.CreatedAt).Milliseconds() {
+		t.Errorf("duration_ms = %d", got.DurationMS)
+	}
+
+	if _, _, err := store.CancelExpression(1, "alice"); err != ErrFinished {
+		t.Errorf("CancelExpression after completion = %v, want ErrFinished", err)
+	}
+	if again, _ := store.GetExpression(1); len(again.Events) != 4 {
+		t.Errorf("history changed after rejected cancel: %+v", again.Events)
+	}
+}
+
+func TestStatusTransitions(t *testing.T) {
+	store := NewStore()
+	store.AddExpression(models.Expression{Name: "1/0", Status: models.StatusPending, Id: 1})
+	if err := store.AddExpression(models.Expression{Name: "1/0", Status: models.StatusRunning, Id: 1}); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("pending -> running: err = %v, want ErrInvalidTransition", err)
+	}
+	store.AddExpression(models.Expression{Name: "1/0", Status: models.StatusQueued, Id: 1})
+	store.AddTasks(1, []models.Task{{ID: "task-expr-1-0", Arg1: "1", Arg2: "0", Operation: "/"}})
+	task, _ := store.GetPendingTask("")
+	store.UpdateTask(models.Result{TaskID: task.ID, Error: "division by zero"})
+
+	expr, _ := store.GetExpression(1)
+	if expr.Status != models.StatusFailed || expr.ErrorDetails == nil || expr.ErrorDetails.Code != "task_failed" || expr.ErrorDetails.TaskID != task.ID {
+		t.Errorf("expression = %s %+v", expr.Status, expr.ErrorDetails)
+	}
+	if err := store.AddExpression(models.Expression{Name: "1/0", Status: models.StatusCompleted, Id: 1}); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("failed -> completed: err = %v, want ErrInvalidTransition", err)
+	}
+}
+
+func TestReleaseTaskAndRestore(t *testing.T) {
+	store := NewStore()
+	node := &models.Node{Value: "*", Left: &models.Node{Value: "+", Left: &models.Node{Value: "1"}, Right: &models.Node{Value: "2"}, TaskID: "task-expr-1-0"}, Right: &models.Node{Value: "4"}, TaskID: "task-expr-1-1"}
+	store.AddExpression(models.Expression{Name: "(1+2)*4", Status: models.StatusQueued, Id: 1, Node: node})
+	store.AddTasks(1, []models.Task{
+		{ID: "task-expr-1-1", Arg1: "task-expr-1-0", Arg2: "4", Operation: "*"},
+		{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"},
+	})
+
+	task, _ := store.GetPendingTask("")
+	store.AgentTookTask("a1", task.ID)
+	if b := store.Backlog(); b.InFlight != 1 || b.Queued != 1 {
+		t.Fatalf("Backlog() = %+v, want 1 in flight and 1 queued", b)
+	}
+	if !store.ReleaseTask(task.ID, "a1") {
+		t.Fatal("ReleaseTask() = false")
+	}
+	if b := store.Backlog(); b.InFlight != 0 || b.Queued != 2 || len(b.Expressions) != 1 {
+		t.Fatalf("Backlog() after release = %+v", b)
+	}
+	if again, ok := store.GetPendingTask(""); !ok || again.ID != task.ID {
+		t.Fatalf("released task was not dispatched again: %+v", again)
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := store.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewStore()
+	maxID, err := restored.Restore(path)
+	if err != nil || maxID != 1 {
+		t.Fatalf("Restore() = %d, %v", maxID, err)
+	}
+	for _, want := range []string{"task-expr-1-0", "task-expr-1-1"} {
+		got, ok := restored.GetPendingTask("")
+		if !ok || got.ID != want {
+			t.Fatalf("GetPendingTask() = %q, %v, want %q", got.ID, ok, want)
+		}
+		restored.UpdateTask(models.Result{TaskID: got.ID, Value: 3})
+	}
+	if expr, _ := restored.GetExpression(1); expr.Status != models.StatusCompleted || expr.Result != 12 {
+		t.Errorf("restored expression = %s %v, want completed 12", expr.Status, expr.Result)
+	}
+}
+
+func TestSnapshotKeepsStatuses(t *testing.T) {
+	store := NewStore()
+	statuses := []models.Status{models.StatusQueued, models.StatusRunning, models.StatusFailed, models.StatusInvalid, models.StatusTimedOut, models.StatusCancelled}
+	for i, status := range statuses {
+		store.AddExpression(models.Expression{Name: "1+2", Status: status, Id: i + 1})
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := store.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewStore()
+	if _, err := restored.Restore(path); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range statuses {
+		if expr, _ := restored.GetExpression(i + 1); expr.Status != want {
+			t.Errorf("expression %d restored as %q, want %q", i+1, expr.Status, want)
+		}
+	}
+}
+
+func TestExpiredLeaseIsReclaimed(t *testing.T) {
+	store := NewStore()
+	store.LeaseTimeout = time.Minute
+	store.AddExpression(models.Expression{Name: "1+2", Status: models.StatusQueued, Id: 1})
+	store.AddTasks(1, []models.Task{{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"}})
+
+	task, _ := store.GetPendingTask("")
+	store.AgentTookTask("a1", task.ID)
+	if task.LeaseMS != time.Minute.Milliseconds() {
+		t.Errorf("LeaseMS = %d, want %d", task.LeaseMS, time.Minute.Milliseconds())
+	}
+	if _, ok := store.GetPendingTask(""); ok {
+		t.Fatal("task dispatched twice while leased")
+	}
+
+	store.Mu.Lock()
+	store.reclaimExpiredLeases(time.Now().Add(2 * time.Minute))
+	store.Mu.Unlock()
+	again, ok := store.GetPendingTask("")
+	if !ok || again.ID != task.ID {
+		t.Fatalf("expired lease was not reclaimed: %+v, %v", again, ok)
+	}
+	if agents := store.GetAgents(); len(agents[0].InFlight) != 0 {
+		t.Errorf("agent still holds reclaimed task: %v", agents[0].InFlight)
+	}
+	if !store.ReleaseTask(task.ID, "a1") || store.Backlog().InFlight != 1 {
+		t.Errorf("release by the previous holder must not requeue the task: %+v", store.Backlog())
+	}
+}
+
+func TestFinishedExpressionsAreRetired(t *testing.T) {
+	store := NewStore()
+	for id := 1; id <= 2; id++ {
+		store.AddExpression(models.Expression{Name: "(1+2)*3", Status: models.StatusQueued, Id: id,
+			Node: &models.Node{Value: "*", Left: &models.Node{Value: "+", Left: &models.Node{Value: "1"}, Right: &models.Node{Value: "2"}}, Right: &models.Node{Value: "3"}}})
+		store.AddTasks(id, []models.Task{
+			{ID: fmt.Sprintf("task-expr-%d-1", id), Arg1: fmt.Sprintf("task-expr-%d-0", id), Arg2: "3", Operation: "*"},
+			{ID: fmt.Sprintf("task-expr-%d-0", id), Arg1: "1", Arg2: "2", Operation: "+"},
+		})
+	}
+
+	for _, want := range []string{"task-expr-1-0", "task-expr-1-1"} {
+		task, ok := store.GetPendingTask("")
+		if !ok || task.ID != want {
+			t.Fatalf("GetPendingTask() = %q, %v, want %q", task.ID, ok, want)
+		}
+		store.UpdateTask(models.Result{TaskID: task.ID, Value: 3})
+	}
+	if _, _, err := store.CancelExpression(2, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.meta) != 0 || len(store.exprTasks) != 0 || len(store.Queues) != 0 {
+		t.Errorf("finished expressions are still tracked: meta %d, expressions %d, queues %d", len(store.meta), len(store.exprTasks), len(store.Queues))
+	}
+	if _, ok := store.GetPendingTask(""); ok {
+		t.Error("task of a cancelled expression was dispatched")
+	}
+	tasks, _ := store.GetExpressionTasks(1)
+	if len(tasks) != 2 || tasks[0].State != TaskDone || tasks[1].State != TaskDone {
+		t.Errorf("GetExpressionTasks(1) = %+v, want two done tasks", tasks)
+	}
+	if tasks, _ := store.GetExpressionTasks(2); len(tasks) != 2 || tasks[0].State != TaskCancelled {
+		t.Errorf("GetExpressionTasks(2) = %+v, want cancelled tasks", tasks)
+	}
+}
+
+func TestWaitPendingTaskWakesOnNewTasks(t *testing.T) {
+	store := NewStore()
+	store.AddExpression(models.Expression{Name: "(1+2)*3", Status: models.StatusQueued, Id: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := store.WaitPendingTask(ctx, ""); ok {
+		t.Fatal("WaitPendingTask() returned a task from an empty store")
+	}
+
+	got := make(chan models.Task)
+	go func() {
+		task, _ := store.WaitPendingTask(context.Background(), "")
+		got <- task
+	}()
+	time.Sleep(10 * time.Millisecond)
+	store.AddTasks(1, []models.Task{
+		{ID: "task-expr-1-1", Arg1: "task-expr-1-0", Arg2: "3", Operation: "*"},
+		{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"},
+	})
+	select {
+	case task := <-got:
+		if task.ID != "task-expr-1-0" {
+			t.Fatalf("woke with %s, want task-expr-1-0", task.ID)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("WaitPendingTask() was not woken by AddTasks")
+	}
+
+	// Зависимая задача готова, как только пришёл результат
+	go func() {
+		task, _ := store.WaitPendingTask(context.Background(), "")
+		got <- task
+	}()
+	time.Sleep(10 * time.Millisecond)
+	store.UpdateTask(models.Result{TaskID: "task-expr-1-0", Value: 3})
+	select {
+	case task := <-got:
+		if task.ID != "task-expr-1-1" {
+			t.Fatalf("woke with %s, want task-expr-1-1", task.ID)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("WaitPendingTask() was not woken by UpdateTask")
+	}
+}
+
+func TestLeaseTasksReturnsOnlyReadyTasks(t *testing.T) {
+	store := NewStore()
+	store.AddExpression(models.Expression{Name: "(1+2)*(3+4)", Status: models.StatusQueued, Id: 1})
+	store.AddTasks(1, []models.Task{
+		{ID: "task-expr-1-2", Arg1: "task-expr-1-0", Arg2: "task-expr-1-1", Operation: "*"},
+		{ID: "task-expr-1-1", Arg1: "3", Arg2: "4", Operation: "+"},
+		{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"},
+	})
+
+	tasks := store.LeaseTasks("", 10)
+	if len(tasks) != 2 {
+		t.Fatalf("LeaseTasks(10) = %d tasks, want the 2 ready ones", len(tasks))
+	}
+	if again := store.LeaseTasks("", 10); len(again) != 0 {
+		t.Fatalf("LeaseTasks() leased %s twice", again[0].ID)
+	}
+
+	for _, task := range tasks {
+		store.UpdateTask(models.Result{TaskID: task.ID, Value: 1})
+	}
+	tasks = store.WaitLeaseTasks(context.Background(), "", 10)
+	if len(tasks) != 1 || tasks[0].ID != "task-expr-1-2" {
+		t.Fatalf("WaitLeaseTasks() = %+v, want task-expr-1-2", tasks)
+	}
+}
+
+func TestTasksAreRoutedByAgentCapabilities(t *testing.T) {
+	store := NewStore()
+	store.AddExpression(models.Expression{Name: "(1+2)*(3/4)+(5+6)", Status: models.StatusQueued, Id: 1})
+	store.AddTasks(1, []models.Task{
+		{ID: "task-expr-1-4", Arg1: "task-expr-1-2", Arg2: "task-expr-1-3", Operation: "+"},
+		{ID: "task-expr-1-3", Arg1: "5", Arg2: "6", Operation: "+"},
+		{ID: "task-expr-1-2", Arg1: "task-expr-1-0", Arg2: "task-expr-1-1", Operation: "*"},
+		{ID: "task-expr-1-1", Arg1: "3", Arg2: "4", Operation: "/"},
+		{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"},
+	})
+	store.RegisterAgent("adder", models.Capabilities{Operations: []string{"+", "*"}, MaxConcurrency: map[string]int{"+": 1}})
+
+	// Деление считать некому, а сложений агент берёт не больше одного за раз
+	tasks := store.LeaseTasks("adder", 10)
+	if len(tasks) != 1 || tasks[0].Operation != "+" {
+		t.Fatalf("LeaseTasks(adder) = %+v, want a single addition", tasks)
+	}
+	infos, _ := store.GetExpressionTasks(1)
+	if division := infos[1]; division.State != TaskBlocked || !strings.Contains(division.Blocked, `"/"`) {
+		t.Errorf("division: state %s, blocked %q, want blocked on /", division.State, division.Blocked)
+	}
+	if reason := store.BlockedReason(1); !strings.Contains(reason, "task-expr-1-1") {
+		t.Errorf("BlockedReason() = %q, want task-expr-1-1", reason)
+	}
+
+	store.UpdateTask(models.Result{TaskID: tasks[0].ID, Value: 3})
+	store.AgentFinishedTask("adder", tasks[0].ID)
+	if next := store.LeaseTasks("adder", 10); len(next) != 1 || next[0].Operation != "+" {
+		t.Fatalf("LeaseTasks(adder) after a result = %+v, want the other addition", next)
+	}
+
+	store.RegisterAgent("divider", models.Capabilities{Operations: []string{"/"}})
+	if got := store.LeaseTasks("divider", 10); len(got) != 1 || got[0].ID != "task-expr-1-1" {
+		t.Fatalf("LeaseTasks(divider) = %+v, want task-expr-1-1", got)
+	}
+	if reason := store.BlockedReason(1); reason != "" {
+		t.Errorf("BlockedReason() = %q once a divider is online", reason)
+	}
+}
+
+func TestDecimalTasksGoToDecimalAgents(t *testing.T) {
+	store := NewStore()
+	store.AddExpression(models.Expression{Name: "0.1+0.2", Status: models.StatusQueued, Id: 1, Decimal: true})
+	store.AddTasks(1, []models.Task{{ID: "task-expr-1-0", Arg1: "0.1", Arg2: "0.2", Operation: "+"}})
+	store.AddExpression(models.Expression{Name: "1+2", Status: models.StatusQueued, Id: 2})
+	store.AddTasks(2, []models.Task{{ID: "task-expr-2-0", Arg1: "1", Arg2: "2", Operation: "+"}})
+
+	// Десятичная задача первая в очереди, но не закрывает от агента обычную
+	store.RegisterAgent("float", models.Capabilities{Operations: []string{"+"}})
+	if got := store.LeaseTasks("float", 10); len(got) != 1 || got[0].ID != "task-expr-2-0" {
+		t.Fatalf("LeaseTasks(float) = %+v, want only task-expr-2-0", got)
+	}
+	if got := store.LeaseTasks("", 10); len(got) != 0 {
+		t.Fatalf("LeaseTasks() by an unregistered agent = %+v, want none", got)
+	}
+	if reason := store.BlockedReason(1); !strings.Contains(reason, "decimal mode") {
+		t.Errorf("BlockedReason() = %q, want decimal mode", reason)
+	}
+
+	store.RegisterAgent("decimal", models.Capabilities{Operations: []string{"+"}, Decimal: true})
+	got := store.LeaseTasks("decimal", 10)
+	if len(got) != 1 || got[0].ID != "task-expr-1-0" || !got[0].Decimal {
+		t.Fatalf("LeaseTasks(decimal) = %+v, want decimal task-expr-1-0", got)
+	}
+}
+
+func TestRetireForgetsTasksAndCapsArchive(t *testing.T) {
+	store := NewStore()
+	store.ArchiveSize = 1
+	for id := 1; id <= 2; id++ {
+		prefix := fmt.Sprintf("task-expr-%d-", id)
+		store.AddExpression(models.Expression{Name: "1+2+3", Status: models.StatusQueued, Id: id})
+		store.AddTasks(id, []models.Task{
+			{ID: prefix + "1", Arg1: prefix + "0", Arg2: "3", Operation: "+"},
+			{ID: prefix + "0", Arg1: "1", Arg2: "2", Operation: "+"},
+		})
+	}
+	store.RegisterAgent("a1", models.Capabilities{Operations: []string{"+"}})
+	leased := store.LeaseTasks("a1", 2)
+	if len(leased) != 2 {
+		t.Fatalf("LeaseTasks() = %+v, want both ready additions", leased)
+	}
+	store.UpdateTask(models.Result{TaskID: "task-expr-1-0", Value: 3})
+	store.AgentFinishedTask("a1", "task-expr-1-0")
+	store.CancelExpression(1, "user")
+
+	store.Mu.Lock()
+	_, kept := store.Tasks["task-expr-1-1"]
+	store.Mu.Unlock()
+	if kept {
+		t.Error("tasks of a cancelled expression are still in Tasks")
+	}
+	if agents := store.GetAgents(); len(agents[0].InFlight) != 1 || agents[0].InFlight[0] != "task-expr-2-0" {
+		t.Errorf("in flight = %v, want only task-expr-2-0", agents[0].InFlight)
+	}
+	if !store.UpdateTask(models.Result{TaskID: "task-expr-1-1", Value: 6}) || !store.Retired("task-expr-1-1") {
+		t.Error("late result of a cancelled expression is not accepted")
+	}
+	if tasks, _ := store.GetExpressionTasks(1); len(tasks) != 2 || tasks[0].State != TaskDone {
+		t.Errorf("archived tasks = %+v, want the done addition and the cancelled one", tasks)
+	}
+
+	// Второе завершённое выражение вытесняет первое из архива, прогресс остаётся
+	store.CancelExpression(2, "user")
+	if tasks, _ := store.GetExpressionTasks(1); len(tasks) != 0 {
+		t.Errorf("tasks of expression 1 = %+v after eviction, want none", tasks)
+	}
+	if expr, _ := store.GetExpression(1); expr.Progress != 50 {
+		t.Errorf("progress = %v after eviction, want 50", expr.Progress)
+	}
+	if tasks, _ := store.GetExpressionTasks(2); len(tasks) != 2 {
+		t.Errorf("tasks of expression 2 = %+v, want 2", tasks)
+	}
+}
+
+func TestConditionalDispatchesOnlyChosenBranch(t *testing.T) {
+	store := NewStore()
+	rpn, _ := parser.InfixToRPN("(2-3)>0?1/0:(4+5)*2")
+	tree, _ := parser.ParseRPN(rpn)
+	tasks, err := parser.BuildTasks("expr-1", tree)
+	if err != nil {
+		t.Fatalf("BuildTasks() error: %v", err)
+	}
+	store.AddExpression(models.Expression{Name: "(2-3)>0?1/0:(4+5)*2", Status: models.StatusQueued, Id: 1, Node: tree})
+	store.AddTasks(1, tasks)
+
+	// Агенту выдаются задачи условия, задача "?" и ветки ждут
+	for _, want := range []string{"-", ">"} {
+		leased := store.LeaseTasks("", 10)
+		if len(leased) != 1 || leased[0].Operation != want {
+			t.Fatalf("LeaseTasks() = %+v, want one %s task", leased, want)
+		}
+		value := -1.0
+		if want == ">" {
+			value = 0
+		}
+		store.UpdateTask(models.Result{TaskID: leased[0].ID, Value: value})
+	}
+
+	// Условие ложно: появляются задачи только ветки (4+5)*2
+	for _, want := range []string{"+", "*"} {
+		leased := store.LeaseTasks("", 10)
+		if len(leased) != 1 || leased[0].Operation != want {
+			t.Fatalf("LeaseTasks() = %+v, want one %s task", leased, want)
+		}
+		store.UpdateTask(models.Result{TaskID: leased[0].ID, Value: map[string]float64{"+": 9, "*": 18}[want]})
+	}
+
+	infos, _ := store.GetExpressionTasks(1)
+	if len(infos) != 5 {
+		t.Errorf("GetExpressionTasks() = %d tasks, want 5 without the 1/0 branch", len(infos))
+	}
+	for _, info := range infos {
+		if info.Operation == "/" {
+			t.Errorf("task %s of the untaken branch was created", info.ID)
+		}
+		if info.Operation == parser.Conditional && (info.State != TaskDone || info.Result != 18) {
+			t.Errorf("condition task = %+v, want done with 18", info)
+		}
+	}
+	if expr, _ := store.GetExpression(1); expr.Status != models.StatusCompleted || expr.Result != 18 {
+		t.Errorf("expression = %s %v, want completed with 18", expr.Status, expr.Result)
+	}
+}
+
+func TestConditionalBranchSharesTasksAndIsCapped(t *testing.T) {
+	for _, tc := range []struct {
+		maxTasks int
+		want     models.Status
+	}{
+		{0, models.StatusCompleted},
+		{3, models.StatusFailed}, // Ветка добавила бы четвёртую задачу
+	} {
+		store := NewStore()
+		store.MaxTasks = tc.maxTasks
+		rpn, _ := parser.InfixToRPN("2+3>4?(2+3)*7:0")
+		tree, _ := parser.ParseRPN(rpn)
+		tasks, _ := parser.BuildTasks("expr-1", tree)
+		store.AddExpression(models.Expression{Name: "2+3>4?(2+3)*7:0", Status: models.StatusQueued, Id: 1, Node: tree})
+		store.AddTasks(1, tasks)
+
+		values := map[string]float64{"+": 5, ">": 1, "*": 35}
+		for leased := store.LeaseTasks("", 10); len(leased) > 0; leased = store.LeaseTasks("", 10) {
+			for _, task := range leased {
+				if task.Operation == "+" && task.ID != "task-expr-1-0" {
+					t.Errorf("branch recomputes 2+3 in %s", task.ID)
+				}
+				store.UpdateTask(models.Result{TaskID: task.ID, Value: values[task.Operation]})
+			}
+		}
+
+		expr, _ := store.GetExpression(1)
+		if expr.Status != tc.want {
+			t.Errorf("MaxTasks %d: expression = %s (%s), want %s", tc.maxTasks, expr.Status, expr.Error, tc.want)
+		}
+		if tc.want == models.StatusCompleted && expr.Result != 35 {
+			t.Errorf("result = %v, want 35", expr.Result)
+		}
+		if tc.want == models.StatusFailed && !strings.Contains(expr.Error, "too complex") {
+			t.Errorf("error = %q, want too complex", expr.Error)
+		}
+	}
+}
+
+func TestExpressionTimesOut(t *testing.T) {
+	store := NewStore()
+	store.ExprTimeout = time.Minute
+	store.AddExpression(models.Expression{Name: "(1+2)*3", Status: models.StatusQueued, Id: 1})
+	store.AddTasks(1, []models.Task{
+		{ID: "task-expr-1-1", Arg1: "task-expr-1-0", Arg2: "3", Operation: "*"},
+		{ID: "task-expr-1-0", Arg1: "1", Arg2: "2", Operation: "+"},
+	})
+	task, _ := store.GetPendingTask("")
+
+	store.Mu.Lock()
+	store.expireExpressions(time.Now().Add(30 * time.Second))
+	store.Mu.Unlock()
+	if expr, _ := store.GetExpression(1); expr.Status != models.StatusRunning {
+		t.Fatalf("expression timed out early: %s", expr.Status)
+	}
+
+	store.Mu.Lock()
+	store.expireExpressions(time.Now().Add(2 * time.Minute))
+	store.Mu.Unlock()
+	expr, _ := store.GetExpression(1)
+	if expr.Status != models.StatusTimedOut || expr.ErrorDetails == nil || expr.ErrorDetails.Code != "timed_out" || expr.FailedAt == nil {
+		t.Fatalf("expression = %s %+v, want timed_out", expr.Status, expr.ErrorDetails)
+	}
+	store.UpdateTask(models.Result{TaskID: task.ID, Value: 3})
+	if _, ok := store.GetPendingTask(""); ok {
+		t.Error("task of a timed out expression was dispatched")
+	}
+	if expr, _ := store.GetExpression(1); expr.Status != models.StatusTimedOut {
+		t.Errorf("late result changed status to %s", expr.Status)
+	}
+}
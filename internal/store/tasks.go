@@ -0,0 +1,175 @@
+package store
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+)
+
+// Состояния задачи
+const (
+	TaskWaiting   = "waiting"   // Ждёт результатов зависимостей
+	TaskReady     = "ready"     // Готова, ждёт свободного агента
+	TaskBlocked   = "blocked"   // Готова, но ни один агент на связи не умеет её операцию
+	TaskLeased    = "leased"    // Выдана агенту
+	TaskDone      = "done"      // Результат получен
+	TaskFailed    = "failed"    // Агент не смог посчитать
+	TaskCancelled = "cancelled" // Выражение отменено до выполнения задачи
+)
+
+// Задача вместе с её состоянием и временем выполнения
+type TaskInfo struct {
+	models.Task
+	Value1     *float64   `json:"arg1_value,omitempty"` // Значение первого операнда, когда оно известно
+	Value2     *float64   `json:"arg2_value,omitempty"`
+	State      string     `json:"state"`
+	Agent      string     `json:"agent,omitempty"`
+	Attempts   int        `json:"attempts"`
+	EnqueuedAt time.Time  `json:"enqueued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMS int64      `json:"duration_ms,omitempty"` // От выдачи агенту до результата
+	Blocked    string     `json:"blocked,omitempty"`     // Почему задача в состоянии blocked
+}
+
+// Возвращает задачи выражения, отсортированные по ID. false, если выражения нет
+func (s *Store) GetExpressionTasks(id int) ([]TaskInfo, bool) {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	expr, exists := s.Expressions[id]
+	if !exists {
+		return nil, false
+	}
+	if tasks, ok := s.archive[id]; ok {
+		return tasks, true
+	}
+	return s.expressionTasks(id, expr, time.Now()), true
+}
+
+// Задачи незавершённого выражения с их состоянием. Вызывается под s.Mu
+func (s *Store) expressionTasks(id int, expr models.Expression, now time.Time) []TaskInfo {
+	var tasks []TaskInfo
+	for _, taskID := range s.exprTasks[id] {
+		task, m := s.Tasks[taskID], s.meta[taskID]
+		info := TaskInfo{Task: task, State: s.taskState(task, m, expr), Agent: m.agent, Attempts: m.attempts, EnqueuedAt: m.enqueuedAt}
+		if info.State == TaskReady {
+			if info.Blocked = s.blockedReason(task, now); info.Blocked != "" {
+				info.State = TaskBlocked
+			}
+		}
+		info.Value1, info.Value2 = s.operandValue(task.Arg1), s.operandValue(task.Arg2)
+		if !m.dispatchedAt.IsZero() {
+			started := m.dispatchedAt
+			info.StartedAt = &started
+		}
+		if !m.finishedAt.IsZero() {
+			finished := m.finishedAt
+			info.FinishedAt = &finished
+			if !m.dispatchedAt.IsZero() {
+				info.DurationMS = m.finishedAt.Sub(m.dispatchedAt).Milliseconds()
+			}
+		}
+		tasks = append(tasks, info)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		return taskIndexLess(tasks[i].ID, tasks[j].ID)
+	})
+	return tasks
+}
+
+// Почему готовые задачи выражения некому выдать: причина для первой такой
+// задачи. Пусто - всё, что готово, может взять какой-нибудь агент
+func (s *Store) BlockedReason(id int) string {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	expr, exists := s.Expressions[id]
+	if !exists || expr.Status.IsFinal() {
+		return ""
+	}
+	now := time.Now()
+	var blocked []string
+	reasons := make(map[string]string)
+	for _, taskID := range s.exprTasks[id] {
+		task := s.Tasks[taskID]
+		if s.taskState(task, s.meta[taskID], expr) != TaskReady {
+			continue
+		}
+		if reason := s.blockedReason(task, now); reason != "" {
+			blocked = append(blocked, taskID)
+			reasons[taskID] = reason
+		}
+	}
+	if len(blocked) == 0 {
+		return ""
+	}
+	sort.Slice(blocked, func(i, j int) bool { return taskIndexLess(blocked[i], blocked[j]) })
+	return "task " + blocked[0] + ": " + reasons[blocked[0]]
+}
+
+// Возвращает значение операнда: число или результат посчитанной задачи, иначе nil
+func (s *Store) operandValue(arg string) *float64 {
+	if value, err := strconv.ParseFloat(arg, 64); err == nil {
+		return &value
+	}
+	if dep, exists := s.Tasks[arg]; exists && dep.Completed {
+		value := dep.Result
+		return &value
+	}
+	return nil
+}
+
+// Доля посчитанных задач выражения в процентах
+func (s *Store) progress(expr models.Expression) float64 {
+	switch {
+	case expr.Status == models.StatusCompleted:
+		return 100
+	case expr.Status.IsFinal():
+		return expr.Progress // Запомнен в retire
+	}
+	return s.taskProgress(expr.Id)
+}
+
+// Доля посчитанных задач незавершённого выражения. Вызывается под s.Mu
+func (s *Store) taskProgress(id int) float64 {
+	total, done := 0, 0
+	for _, taskID := range s.exprTasks[id] {
+		total++
+		if s.Tasks[taskID].Completed {
+			done++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(done)*1000/float64(total)) / 10
+}
+
+func (s *Store) taskState(task models.Task, m *taskMeta, expr models.Expression) string {
+	switch {
+	case task.Completed:
+		return TaskDone
+	case m.failed:
+		return TaskFailed
+	case !m.dispatchedAt.IsZero():
+		return TaskLeased
+	case expr.Status.IsFinal():
+		return TaskCancelled
+	case s.isTaskReady(task):
+		return TaskReady
+	default:
+		return TaskWaiting
+	}
+}
+
+// Сравнивает ID задач одного выражения по номеру, чтобы task-expr-1-10 шла после task-expr-1-9
+func taskIndexLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"container/heap"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+)
+
+// Срок выражения
+type deadline struct {
+	exprID int
+	until  time.Time
+}
+
+// Сроки выражений: сверху тот, что истекает первым. Записи завершённых
+// выражений не удаляются, а пропускаются при разборе
+type deadlineHeap []deadline
+
+func (h deadlineHeap) Len() int            { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool  { return h[i].until.Before(h[j].until) }
+func (h deadlineHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *deadlineHeap) Push(x interface{}) { *h = append(*h, x.(deadline)) }
+
+func (h *deadlineHeap) Pop() interface{} {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
+
+// Запоминает срок выражения, если он задан: ExprTimeout с момента
+// приёма. Вызывается под s.Mu
+func (s *Store) scheduleTimeout(id int) {
+	if s.ExprTimeout <= 0 {
+		return
+	}
+	created := s.Expressions[id].CreatedAt
+	if created.IsZero() {
+		created = time.Now()
+	}
+	heap.Push(&s.deadlines, deadline{exprID: id, until: created.Add(s.ExprTimeout)})
+}
+
+// Завершает статусом timed_out выражения, срок которых истёк, и снимает их
+// задачи с очереди. Результаты задач, уже выданных агентам, будут
+// проигнорированы. Вызывается под s.Mu
+func (s *Store) expireExpressions(now time.Time) {
+	for len(s.deadlines) > 0 && !now.Before(s.deadlines[0].until) {
+		d := heap.Pop(&s.deadlines).(deadline)
+		expr, exists := s.Expressions[d.exprID]
+		if !exists || expr.Status.IsFinal() {
+			continue
+		}
+		message := fmt.Sprintf("not computed within %s", s.ExprTimeout)
+		if err := setStatus(&expr, models.StatusTimedOut, "scheduler", message, now); err != nil {
+			log.Printf("Выражение %d: %v", d.exprID, err)
+			continue
+		}
+		expr.Error = message
+		expr.ErrorDetails = &models.StatusError{Code: "timed_out", Message: message}
+		s.Expressions[d.exprID] = expr
+		s.retire(d.exprID)
+		log.Printf("Выражение %d не посчитано за %s", d.exprID, s.ExprTimeout)
+	}
+}
@@ -1,10 +1,14 @@
 package models
 
+import "time"
+
 // Node представляет узел дерева операций
 type Node struct {
-	Value string `json:"value"`
-	Left  *Node  `json:"left,omitempty"`
-	Right *Node  `json:"right,omitempty"`
+	Value  string `json:"value"`
+	Cond   *Node  `json:"cond,omitempty"` // Условие узла "?": Left считается, если оно не ноль, иначе Right
+	Left   *Node  `json:"left,omitempty"`
+	Right  *Node  `json:"right,omitempty"`
+	TaskID string `json:"task_id,omitempty"` // Задача, которая вычисляет этот узел (проставляет BuildTasks)
 }
 
 // Task представляет задачу для вычисления
@@ -12,9 +16,12 @@ type Task struct {
 	ID        string  `json:"id"`
 	Arg1      string  `json:"arg1"`
 	Arg2      string  `json:"arg2"`
-	Operation string  `json:"operation"` // (+ - / *)
+	Operation string  `json:"operation"` // Операция из реестра ops или "?" для условия
 	Result    float64 `json:"result,omitempty"`
 	Completed bool    `json:"completed"`
+	Hash      string  `json:"-"`                  // Хэш канонической записи поддерева, по нему кэшируется результат
+	LeaseMS   int64   `json:"lease_ms,omitempty"` // Сколько мс агент может считать задачу, потом оркестратор выдаст её снова
+	Decimal   bool    `json:"decimal,omitempty"`  // Считать в десятичной арифметике; выдаётся только агентам с Capabilities.Decimal
 }
 
 // Result представляет результат выполнения задачи
@@ -22,13 +29,82 @@ type Result struct {
 	TaskID string  `json:"task_id"`
 	Value  float64 `json:"value"`
 	Error  string  `json:"error,omitempty"`
+
+	Released bool `json:"released,omitempty"` // Агент не посчитал задачу и возвращает её в очередь
+}
+
+// ResultAck - ответ оркестратора на один результат из пачки
+type ResultAck struct {
+	TaskID string `json:"task_id"`
+	Status int    `json:"status"`          // HTTP-код, как если бы результат прислали отдельно
+	Error  string `json:"error,omitempty"` // Почему результат не принят
+}
+
+// Что умеет агент; агент сообщает это оркестратору при регистрации
+type Capabilities struct {
+	Operations     []string       `json:"operations"`                // Операции, которые агент умеет считать
+	Decimal        bool           `json:"decimal,omitempty"`         // Считает в десятичной арифметике без ошибок округления, например 0.1+0.2=0.3
+	MaxConcurrency map[string]int `json:"max_concurrency,omitempty"` // Сколько задач с операцией агент считает одновременно; нет ключа - без ограничения
+}
+
+// Умеет ли агент считать операцию
+func (c Capabilities) Supports(operation string) bool {
+	for _, op := range c.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// Сведения об агенте, который обращается к оркестратору
+type AgentInfo struct {
+	ID        string    `json:"id"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Online    bool      `json:"online"`
+	InFlight  []string  `json:"in_flight"` // Задачи, выданные агенту и ещё не вернувшиеся
+	Completed int       `json:"completed"`
+
+	Capabilities *Capabilities `json:"capabilities,omitempty"` // Что агент умеет; nil - не регистрировался и получает любые задачи
 }
 
 // Expression представляет арифметическое выражение
 type Expression struct {
 	Name   string  `json:"name"`
-	Status int     `json:"status"` // 0: посчиталось, 1: считается, 2: ожидает вычисления, 3: невалидно
+	Status Status  `json:"status"`
 	Id     int     `json:"id"`
 	Result float64 `json:"result"`
 	Node   *Node   `json:"node,omitempty"`
+	Error  string  `json:"error,omitempty"` // Почему выражение не посчиталось
+
+	ErrorDetails *StatusError `json:"error_details,omitempty"` // Подробности ошибки для failed, invalid, cancelled и timed_out
+
+	Owner    string `json:"owner,omitempty"`   // Кто отправил выражение (пользователь или IP)
+	Priority int    `json:"priority"`          // Приоритет от 0 (обычный) до 9 (наивысший)
+	Cached   bool   `json:"cached,omitempty"`  // Результат целиком взят из кэша
+	Decimal  bool   `json:"decimal,omitempty"` // Считается в десятичной арифметике, см. Capabilities.Decimal
+
+	Progress float64 `json:"progress"` // Процент посчитанных задач
+
+	CreatedAt   time.Time  `json:"created_at"`             // Когда выражение принято
+	StartedAt   *time.Time `json:"started_at,omitempty"`   // Когда первая задача выдана агенту
+	CompletedAt *time.Time `json:"completed_at,omitempty"` // Когда получен результат
+	FailedAt    *time.Time `json:"failed_at,omitempty"`    // Когда выражение признано невалидным
+	CancelledAt *time.Time `json:"cancelled_at,omitempty"`
+	DurationMS  int64      `json:"duration_ms"`      // От приёма до завершения, для незавершённых - до текущего момента
+	Events      []Event    `json:"events,omitempty"` // История переходов, только дописывается
+
+	PredictedCompletion  string `json:"predicted_completion,omitempty"`   // Ожидаемое время завершения (RFC3339)
+	PredictedRemainingMS int64  `json:"predicted_remaining_ms,omitempty"` // Сколько ещё считать по критическому пути
+	Blocked              string `json:"blocked,omitempty"`                // Почему готовые задачи выражения некому выдать
+}
+
+// Event - запись в истории выражения
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`            // submitted, queued, started, completed, failed, invalid, cancelled, timed_out
+	Status Status    `json:"status"`          // Статус выражения после события
+	Actor  string    `json:"actor,omitempty"` // Кто вызвал переход: пользователь, оркестратор или агент
+	Detail string    `json:"detail,omitempty"`
 }
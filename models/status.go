@@ -0,0 +1,141 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Status - состояние выражения
+type Status string
+
+const (
+	StatusPending   Status = "pending"   // Принято, разбирается
+	StatusQueued    Status = "queued"    // Задачи в очереди, ни одна ещё не выдана агенту
+	StatusRunning   Status = "running"   // Агенты считают задачи
+	StatusCompleted Status = "completed" // Результат посчитан
+	StatusFailed    Status = "failed"    // Агент или оркестратор не смогли посчитать
+	StatusInvalid   Status = "invalid"   // Выражение не прошло разбор или проверки
+	StatusCancelled Status = "cancelled" // Отменено пользователем
+	StatusTimedOut  Status = "timed_out" // Не посчиталось за отведённое время
+)
+
+// Разрешённые переходы между статусами. Из конечных статусов переходов нет
+var transitions = map[Status][]Status{
+	StatusPending: {StatusQueued, StatusCompleted, StatusInvalid, StatusCancelled},
+	StatusQueued:  {StatusRunning, StatusFailed, StatusCancelled, StatusTimedOut},
+	StatusRunning: {StatusCompleted, StatusFailed, StatusCancelled, StatusTimedOut},
+}
+
+// Прежние числовые коды статусов: 0 посчиталось, 1 считается, 2 ожидает, 3 невалидно, 4 отменено
+var legacyCodes = map[Status]int{
+	StatusCompleted: 0,
+	StatusQueued:    1,
+	StatusRunning:   1,
+	StatusPending:   2,
+	StatusFailed:    3,
+	StatusInvalid:   3,
+	StatusTimedOut:  3,
+	StatusCancelled: 4,
+}
+
+func (s Status) String() string {
+	return string(s)
+}
+
+// Проверяет, что статус известен
+func (s Status) Valid() bool {
+	_, ok := legacyCodes[s]
+	return ok
+}
+
+// Конечный статус: выражение больше не изменится
+func (s Status) IsFinal() bool {
+	return s.Valid() && len(transitions[s]) == 0
+}
+
+// Проверяет, разрешён ли переход из s в next
+func (s Status) CanTransition(next Status) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// Прежний числовой код статуса
+func (s Status) Legacy() int {
+	if code, ok := legacyCodes[s]; ok {
+		return code
+	}
+	return -1
+}
+
+// Выражение со статусами прежними числами, для старых клиентов
+type LegacyExpression struct {
+	Expression
+	Status int           `json:"status"`
+	Events []LegacyEvent `json:"events,omitempty"`
+}
+
+// Событие истории со статусом прежним числом
+type LegacyEvent struct {
+	Event
+	Status int `json:"status"`
+}
+
+// Выражение в виде для старых клиентов, см. Legacy
+func (e Expression) Legacy() LegacyExpression {
+	legacy := LegacyExpression{Expression: e, Status: e.Status.Legacy()}
+	for _, event := range e.Events {
+		legacy.Events = append(legacy.Events, LegacyEvent{Event: event, Status: event.Status.Legacy()})
+	}
+	return legacy
+}
+
+// Принимает и строки, и прежние числовые коды
+func (s *Status) UnmarshalJSON(data []byte) error {
+	var code int
+	if err := json.Unmarshal(data, &code); err == nil {
+		status, ok := StatusFromLegacy(code)
+		if !ok {
+			return fmt.Errorf("unknown status code %d", code)
+		}
+		*s = status
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	if !Status(name).Valid() {
+		return fmt.Errorf("unknown status %q", name)
+	}
+	*s = Status(name)
+	return nil
+}
+
+// Статус по прежнему числовому коду
+func StatusFromLegacy(code int) (Status, bool) {
+	switch code {
+	case 0:
+		return StatusCompleted, true
+	case 1:
+		return StatusRunning, true
+	case 2:
+		return StatusPending, true
+	case 3:
+		return StatusInvalid, true
+	case 4:
+		return StatusCancelled, true
+	}
+	return "", false
+}
+
+// StatusError - подробности о том, почему выражение не посчиталось
+type StatusError struct {
+	Code    string `json:"code"` // parse_error, too_complex, task_failed, evaluation_error, cancelled, timed_out
+	Message string `json:"message"`
+	TaskID  string `json:"task_id,omitempty"` // Задача, на которой произошла ошибка
+	Actor   string `json:"actor,omitempty"`   // Кто отменил выражение
+}
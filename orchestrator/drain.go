@@ -0,0 +1,77 @@
+package orchestrator
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	drainPollInterval = 100 * time.Millisecond
+	drainAgentIdle    = 3 * time.Second // Агент, молчащий дольше, уже не заберёт задачи из очереди
+	shutdownTimeout   = 5 * time.Second // Сколько ждать открытые соединения после ожидания задач
+)
+
+// Отклоняет новые выражения с 503, пока оркестратор останавливается
+func (o *Orchestrator) accepting(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if o.draining.Load() {
+			w.Header().Set("Retry-After", strconv.Itoa(max(o.Config.DrainTimeoutSec, 1)))
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Останавливает оркестратор: новые выражения отклоняются, агенты продолжают
+// получать задачи из очереди и присылать результаты, пока работа не кончится
+// или не истечёт DrainTimeoutSec. Затем сохраняется состояние и в журнал
+// пишется, что осталось несделанным
+func (o *Orchestrator) shutdown() error {
+	o.draining.Store(true)
+	grace := time.Duration(o.Config.DrainTimeoutSec) * time.Second
+	log.Printf("Останавливаем оркестратор: новые выражения не принимаются, ждём задачи у агентов до %s", grace)
+
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		backlog := o.Store.Backlog()
+		if backlog.InFlight == 0 && (backlog.Queued == 0 || !o.agentsPolling()) {
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := o.Server.Shutdown(ctx)
+
+	backlog := o.Store.Backlog()
+	if o.Config.StateFile != "" {
+		if err := o.Store.Save(o.Config.StateFile); err != nil {
+			log.Printf("Не удалось сохранить состояние в %s: %v", o.Config.StateFile, err)
+		} else {
+			log.Printf("Состояние сохранено в %s", o.Config.StateFile)
+		}
+	}
+	if len(backlog.Expressions) == 0 {
+		log.Println("Оркестратор остановлен, все выражения посчитаны")
+	} else {
+		log.Printf("Оркестратор остановлен, не завершено выражений: %d %v, задач в очереди: %d, не вернулось от агентов: %d",
+			len(backlog.Expressions), backlog.Expressions, backlog.Queued, backlog.InFlight)
+	}
+	return err
+}
+
+// Есть ли агенты, которые ещё обращаются за задачами
+func (o *Orchestrator) agentsPolling() bool {
+	now := time.Now()
+	for _, agent := range o.Store.GetAgents() {
+		if now.Sub(agent.LastSeen) < drainAgentIdle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/NieR8/myProject/internal/graph"
+)
+
+// Форматы выгрузки графа и их Content-Type
+var graphFormats = map[string]struct {
+	contentType string
+	write       func(io.Writer, *graph.Graph) error
+}{
+	"dot":     {"text/vnd.graphviz; charset=utf-8", graph.WriteDOT},
+	"mermaid": {"text/plain; charset=utf-8", graph.WriteMermaid},
+	"svg":     {"image/svg+xml", graph.WriteSVG},
+	"json": {"application/json", func(w io.Writer, g *graph.Graph) error {
+		return json.NewEncoder(w).Encode(g)
+	}},
+}
+
+// Отдаёт дерево выражения и граф его задач: GET /api/v1/expressions/{id}/graph?format=dot|mermaid|svg|json
+func (o *Orchestrator) handleGetExpressionGraph(w http.ResponseWriter, r *http.Request, id int) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "json"
+	}
+	format, ok := graphFormats[name]
+	if !ok {
+		http.Error(w, "Unknown format: use dot, mermaid, svg or json", http.StatusBadRequest)
+		return
+	}
+
+	expr, exists := o.Store.GetExpression(id)
+	if !exists {
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+	tasks, _ := o.Store.GetExpressionTasks(id)
+
+	w.Header().Set("Content-Type", format.contentType)
+	format.write(w, graph.Build(expr, tasks))
+}
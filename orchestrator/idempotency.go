@@ -0,0 +1,83 @@
+package orchestrator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Повторяет сохранённый ответ на запрос с тем же заголовком Idempotency-Key,
+// чтобы ретраи клиента не создавали дубликаты выражений. Ключ с другим телом
+// запроса отклоняется с 409
+func (o *Orchestrator) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || header == "" {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request", http.StatusInternalServerError)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		key := requestOwner(r) + "\x00" + header // Ключи разных клиентов не пересекаются
+		saved, reserved := o.Store.ReserveIdempotencyKey(key, fingerprint, time.Now())
+		if !reserved {
+			switch {
+			case saved.Fingerprint != fingerprint:
+				http.Error(w, "Idempotency-Key was already used with a different request", http.StatusConflict)
+			case saved.Pending:
+				http.Error(w, "Request with this Idempotency-Key is still in progress", http.StatusConflict)
+			default:
+				log.Printf("Повтор запроса с Idempotency-Key %q, выражение %d", header, saved.ExprID)
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(saved.StatusCode)
+				w.Write(saved.Body)
+			}
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// Временные ошибки не запоминаем: клиент должен иметь возможность повторить запрос
+		if rec.status >= http.StatusInternalServerError || rec.status == http.StatusTooManyRequests {
+			o.Store.ReleaseIdempotencyKey(key)
+			return
+		}
+		var created struct {
+			ID int `json:"id"`
+		}
+		json.Unmarshal(rec.body.Bytes(), &created)
+		o.Store.CompleteIdempotencyKey(key, created.ID, rec.status, rec.body.Bytes())
+	}
+}
+
+// Пишет ответ клиенту и одновременно запоминает его
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,42 @@
+package orchestrator
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/NieR8/myProject/internal/env"
+)
+
+// Применяет перезагруженную конфигурацию: новое время операций учитывается
+// в критическом пути и справедливой очереди для следующих задач
+func (o *Orchestrator) ApplyConfig(config env.Config) {
+	o.Store.SetOperationCosts(config.OperationCosts())
+}
+
+// Отдаёт метрики в текстовом формате Prometheus
+func (o *Orchestrator) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var b strings.Builder
+	if o.Reloader != nil {
+		stats := o.Reloader.Stats()
+		b.WriteString("# HELP calc_config_reloads_total Перезагрузки конфигурации по SIGHUP или изменению файла.\n")
+		b.WriteString("# TYPE calc_config_reloads_total counter\n")
+		fmt.Fprintf(&b, "calc_config_reloads_total{result=\"success\"} %d\n", stats.Succeeded)
+		fmt.Fprintf(&b, "calc_config_reloads_total{result=\"failure\"} %d\n", stats.Failed)
+		b.WriteString("# HELP calc_config_last_reload_timestamp_seconds Время последней успешной перезагрузки.\n")
+		b.WriteString("# TYPE calc_config_last_reload_timestamp_seconds gauge\n")
+		last := 0.0
+		if !stats.LastReload.IsZero() {
+			last = float64(stats.LastReload.UnixNano()) / 1e9
+		}
+		fmt.Fprintf(&b, "calc_config_last_reload_timestamp_seconds %.3f\n", last)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
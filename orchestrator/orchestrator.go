@@ -5,46 +5,83 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/NieR8/myProject/internal/api"
+	"github.com/NieR8/myProject/internal/cache"
+	"github.com/NieR8/myProject/internal/env"
+	"github.com/NieR8/myProject/internal/ratelimit"
 	"github.com/NieR8/myProject/internal/store"
 	"github.com/NieR8/myProject/models"
 	"github.com/NieR8/myProject/pkg/parser"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 )
 
 type Orchestrator struct {
 	Addr        string
 	Server      *http.Server
 	Store       *store.Store
+	Config      env.Config
+	Reloader    *env.Reloader // Источник перезагрузок конфигурации для метрик, может быть nil
 	taskCounter uint64
+	draining    atomic.Bool // Оркестратор останавливается и не принимает новые выражения
+
+	ipLimiter   *ratelimit.Limiter
+	userLimiter *ratelimit.Limiter
+	quota       *ratelimit.Quota
+	cache       *cache.Cache
 }
 
-func NewOrchestrator(addr string) *Orchestrator {
+// Создаёт оркестратор, слушающий config.OrchestratorAddr
+func NewOrchestrator(config env.Config) *Orchestrator {
+	addr := config.OrchestratorAddr
 	st := store.NewStore()
-	return &Orchestrator{
-		Addr:  addr,
-		Store: st,
+	st.OperationCosts = config.OperationCosts()
+	st.IdempotencyTTL = time.Duration(config.IdempotencyTTLSec) * time.Second
+	st.LeaseTimeout = time.Duration(config.LeaseTimeoutSec) * time.Second
+	st.MaxTasks = config.MaxTasks
+	st.ExprTimeout = time.Duration(config.ExprTimeoutSec) * time.Second
+	o := &Orchestrator{
+		Addr:   addr,
+		Store:  st,
+		Config: config,
 		Server: &http.Server{
 			Addr:    addr,
 			Handler: nil,
 		},
+		ipLimiter:   ratelimit.NewLimiter(config.RateLimitIPPerMin, config.RateLimitIPBurst),
+		userLimiter: ratelimit.NewLimiter(config.RateLimitUserPerMin, config.RateLimitUserBurst),
+		quota:       ratelimit.NewQuota(config.DailyQuota),
+		cache:       cache.New(config.CacheSize, time.Duration(config.CacheTTLSec)*time.Second),
+	}
+	st.OnTaskDone = func(task models.Task) {
+		if task.Hash != "" && !task.Decimal { // В кэше результаты в float64, десятичные с ними не смешиваем
+			o.cache.Put(task.Hash, task.Result) // Результат поддерева пригодится другим выражениям
+		}
 	}
+	return o
 }
 
+// Обслуживает запросы, пока не отменён ctx, затем останавливается через shutdown
 func (o *Orchestrator) Run(ctx context.Context) error {
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/api/v1/calculate", o.handleCalculate)
-	mux.HandleFunc("/api/v1/expressions", o.handleGetExpressions)
-	mux.HandleFunc("/api/v1/expressions/", o.handleGetExpressionByID)
-	mux.HandleFunc("/internal/task", api.HandleTask(o.Store))
-	mux.HandleFunc("/internal/task/result/", api.HandleTaskResult(o.Store))
-	mux.HandleFunc("/api/v1/pending-tasks", o.handleGetPendingTasks) // эндпоинт для мониторинга еще незавершенных задач
+	if o.Config.StateFile != "" {
+		maxID, err := o.Store.Restore(o.Config.StateFile)
+		if err != nil {
+			return fmt.Errorf("restore state: %w", err)
+		}
+		atomic.StoreUint64(&o.taskCounter, uint64(maxID)) // Новые выражения получают следующие ID
+	}
 
-	o.Server.Handler = mux
+	o.Server.Handler = o.routes()
+	// Контекст запросов отменяется при остановке сервера, чтобы агенты,
+	// ждущие задачу (GET /internal/task?wait=), не задерживали Shutdown
+	base, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	o.Server.BaseContext = func(net.Listener) context.Context { return base }
+	o.Server.RegisterOnShutdown(cancelRequests)
 
 	go func() {
 		if err := o.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -53,8 +90,28 @@ func (o *Orchestrator) Run(ctx context.Context) error {
 	}()
 
 	<-ctx.Done()
-	log.Println("Останавливаем оркестратор")
-	return o.Server.Shutdown(context.Background())
+	return o.shutdown()
+}
+
+// Обработчики API, внутренних эндпоинтов для агентов и веб-интерфейса
+func (o *Orchestrator) routes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v1/calculate", o.accepting(o.idempotent(o.rateLimited(o.handleCalculate))))
+	mux.HandleFunc("/api/v1/expressions", o.handleGetExpressions)
+	mux.HandleFunc("/api/v1/expressions/", o.handleGetExpressionByID)
+	mux.HandleFunc("/internal/task", api.HandleTask(o.Store))
+	mux.HandleFunc("/internal/task/result/", api.HandleTaskResult(o.Store))
+	mux.HandleFunc("/internal/tasks", api.HandleTasks(o.Store))
+	mux.HandleFunc("/internal/results", api.HandleResults(o.Store))
+	mux.HandleFunc("/internal/agents", api.HandleAgents(o.Store))
+	mux.HandleFunc("/api/v1/pending-tasks", o.handleGetPendingTasks) // эндпоинт для мониторинга еще незавершенных задач
+	mux.HandleFunc("/api/v1/cache/stats", o.handleGetCacheStats)
+	mux.HandleFunc("/api/v1/agents", o.handleGetAgents)
+	mux.HandleFunc("/metrics", o.handleMetrics)
+	mux.HandleFunc("/api/", handleAPINotFound)
+	mux.Handle("/", webHandler()) // Веб-интерфейс
+	return mux
 }
 
 // Принимает POST-запросы, парсит выражение, создаёт задачи и добавляет их в очередь
@@ -66,65 +123,87 @@ func (o *Orchestrator) handleCalculate(w http.ResponseWriter, r *http.Request) {
 
 	var req struct {
 		Expression string `json:"expression"`
+		Priority   int    `json:"priority"`
+		NoCache    bool   `json:"no_cache"` // Не брать результаты из кэша
+		Decimal    bool   `json:"decimal"`  // Считать в десятичной арифметике
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Expression == "" {
 		http.Error(w, "Invalid request", http.StatusInternalServerError)
 		return
 	}
+	if o.Config.MaxExpressionLength > 0 && len(req.Expression) > o.Config.MaxExpressionLength {
+		http.Error(w, fmt.Sprintf("Expression too long: limit is %d characters", o.Config.MaxExpressionLength), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if req.Priority < store.MinPriority || req.Priority > store.MaxPriority {
+		http.Error(w, fmt.Sprintf("Invalid priority: must be from %d to %d", store.MinPriority, store.MaxPriority), http.StatusUnprocessableEntity)
+		return
+	}
 
 	id := int(atomic.AddUint64(&o.taskCounter, 1))
 	expr := models.Expression{
-		Name:   req.Expression,
-		Status: 2,
-		Id:     id,
+		Name:     req.Expression,
+		Status:   models.StatusPending,
+		Id:       id,
+		Owner:    requestOwner(r),
+		Priority: req.Priority,
+		Decimal:  req.Decimal,
 	}
 
-	o.Store.AddExpression(expr)
-	log.Printf("Выражение %d со статусом 2 добавлено в Store: %+v", id, expr)
+	o.saveExpression(expr)
+	log.Printf("Выражение %d со статусом %s добавлено в Store: %+v", id, expr.Status, expr)
 
 	rpn, err := parser.InfixToRPN(req.Expression)
 	if err != nil {
-		expr.Status = 3
-		o.Store.AddExpression(expr)
-		http.Error(w, "Invalid expression: "+err.Error(), http.StatusUnprocessableEntity)
+		o.rejectExpression(w, expr, "parse_error", "invalid expression: "+err.Error(), "Invalid expression: "+err.Error())
 		return
 	}
 
 	tree, err := parser.ParseRPN(rpn)
 	if err != nil {
-		expr.Status = 3
-		o.Store.AddExpression(expr)
-		http.Error(w, "Failed to parse expression", http.StatusUnprocessableEntity)
+		o.rejectExpression(w, expr, "parse_error", "failed to parse expression: "+err.Error(), "Failed to parse expression")
 		return
 	}
 
+	if o.Config.FoldConstants && !req.Decimal { // Оркестратор считает в float64, десятичные константы сворачивают агенты
+		tree = parser.Optimize(tree) // Сворачиваем константы, чтобы не гонять агентов впустую
+	} else {
+		tree = parser.Simplify(tree)
+	}
+	if !req.NoCache && !req.Decimal && r.Header.Get("Cache-Control") != "no-cache" {
+		cached := parser.Substitute(tree, o.cache.Peek) // Уже посчитанные поддеревья заменяем результатами
+		if parser.IsCompound(tree.Value) {
+			o.cache.Count(parser.Hash(cached) != parser.Hash(tree)) // Одно обращение на выражение, сколько бы поддеревьев ни нашлось
+		}
+		expr.Cached = parser.IsCompound(tree.Value) && !parser.IsCompound(cached.Value)
+		tree = cached
+	}
 	expr.Node = tree
 	tasks, err := parser.BuildTasks(fmt.Sprintf("expr-%d", id), tree)
 	if err != nil {
-		expr.Status = 3
-		o.Store.AddExpression(expr)
-		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		o.rejectExpression(w, expr, "parse_error", err.Error(), err.Error())
+		return
+	}
+	if o.Config.MaxTasks > 0 && len(tasks) > o.Config.MaxTasks {
+		message := fmt.Sprintf("too complex: %d operations, limit is %d", len(tasks), o.Config.MaxTasks)
+		o.rejectExpression(w, expr, "too_complex", message, "Expression "+message)
 		return
 	}
 
-	if len(tasks) == 0 && tree != nil && !parser.IsOperator(tree.Value) { // Если задач нет и это просто одно число
+	if len(tasks) == 0 && tree != nil && !parser.IsCompound(tree.Value) { // Если задач нет и это просто одно число
 		result, err := strconv.ParseFloat(tree.Value, 64)
 		if err != nil {
-			expr.Status = 3
-			o.Store.AddExpression(expr)
-			http.Error(w, "Invalid number: "+err.Error(), http.StatusUnprocessableEntity)
+			o.rejectExpression(w, expr, "invalid_number", "invalid number: "+err.Error(), "Invalid number: "+err.Error())
 			return
 		}
-		expr.Status = 0
+		expr.Status = models.StatusCompleted
 		expr.Result = result
-		o.Store.AddExpression(expr)
+		o.saveExpression(expr)
 		log.Printf("Выражение %d завершено без задач: %+v", id, expr)
 	} else {
-		expr.Status = 1
-		for i := len(tasks) - 1; i >= 0; i-- {
-			o.Store.AddTask(tasks[i])
-		}
-		o.Store.AddExpression(expr)
+		expr.Status = models.StatusQueued
+		o.saveExpression(expr) // Сначала статус, иначе быстрый агент может завершить выражение раньше
+		o.Store.AddTasks(id, tasks)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -134,6 +213,22 @@ func (o *Orchestrator) handleCalculate(w http.ResponseWriter, r *http.Request) {
 	}{ID: id})
 }
 
+// Сохраняет выражение; недопустимая смена статуса означает ошибку в оркестраторе
+func (o *Orchestrator) saveExpression(expr models.Expression) {
+	if err := o.Store.AddExpression(expr); err != nil {
+		log.Printf("Выражение %d не сохранено: %v", expr.Id, err)
+	}
+}
+
+// Помечает выражение невалидным и отвечает клиенту 422
+func (o *Orchestrator) rejectExpression(w http.ResponseWriter, expr models.Expression, code, message, response string) {
+	expr.Status = models.StatusInvalid
+	expr.Error = message
+	expr.ErrorDetails = &models.StatusError{Code: code, Message: message}
+	o.saveExpression(expr)
+	http.Error(w, response, http.StatusUnprocessableEntity)
+}
+
 // Возвращает список всех выражений
 func (o *Orchestrator) handleGetExpressions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -141,66 +236,142 @@ func (o *Orchestrator) handleGetExpressions(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	expressions := o.Store.GetAllExpressions()
+	var expressions []interface{}
+	for _, expr := range o.Store.GetAllExpressions() {
+		expressions = append(expressions, o.expressionJSON(expr))
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(struct {
-		Expressions []models.Expression `json:"expressions"`
+		Expressions []interface{} `json:"expressions"`
 	}{Expressions: expressions})
 }
 
-// Возвращает конкретное выражение
+// Выражение для ответа: со статусами прежними числами, если так настроено
+func (o *Orchestrator) expressionJSON(expr models.Expression) interface{} {
+	if o.Config.LegacyStatusCodes {
+		return expr.Legacy()
+	}
+	return expr
+}
+
+// Возвращает конкретное выражение, а на DELETE отменяет его
 func (o *Orchestrator) handleGetExpressionByID(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/")
+	idStr, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/"), "/")
 	id, err := strconv.Atoi(idStr)
 	if err != nil || idStr == "" {
 		http.Error(w, "Invalid or missing ID", http.StatusBadRequest)
 		return
 	}
 
+	switch {
+	case sub == "graph" && r.Method == http.MethodGet:
+		o.handleGetExpressionGraph(w, r, id)
+		return
+	case sub == "tasks" && r.Method == http.MethodGet:
+		o.handleGetExpressionTasks(w, id)
+		return
+	case sub != "":
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		o.handleCancelExpression(w, r, id)
+		return
+	}
+
 	expr, exists := o.Store.GetExpression(id)
 	if !exists {
 		http.Error(w, "Expression not found", http.StatusNotFound)
 		return
 	}
+	if finish, ok := o.Store.PredictCompletion(id); ok && (expr.Status == models.StatusQueued || expr.Status == models.StatusRunning) {
+		expr.PredictedCompletion = finish.Format(time.RFC3339Nano)
+		expr.PredictedRemainingMS = time.Until(finish).Milliseconds()
+	}
+	expr.Blocked = o.Store.BlockedReason(id)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(struct {
-		Expression models.Expression `json:"expression"`
-	}{Expression: expr})
+		Expression interface{} `json:"expression"`
+	}{Expression: o.expressionJSON(expr)})
 }
 
-func (o *Orchestrator) handleGetPendingTasks(w http.ResponseWriter, r *http.Request) {
+// Возвращает задачи выражения с операндами, состоянием, агентом и временем выполнения
+func (o *Orchestrator) handleGetExpressionTasks(w http.ResponseWriter, id int) {
+	tasks, exists := o.Store.GetExpressionTasks(id)
+	if !exists {
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+	if tasks == nil {
+		tasks = []store.TaskInfo{} // Выражение посчитано без задач: из кэша или сворачиванием констант
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Tasks []store.TaskInfo `json:"tasks"`
+	}{Tasks: tasks})
+}
+
+// Отменяет выражение, если оно ещё не посчитано
+func (o *Orchestrator) handleCancelExpression(w http.ResponseWriter, r *http.Request, id int) {
+	expr, exists, err := o.Store.CancelExpression(id, requestOwner(r))
+	if !exists {
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Expression already finished", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Expression interface{} `json:"expression"`
+	}{Expression: o.expressionJSON(expr)})
+}
+
+// Возвращает агентов, которые обращались к оркестратору
+func (o *Orchestrator) handleGetAgents(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var tasks []models.Task
-	o.Store.Mu.Lock()
-	//for i := 0; i < cap(o.Store.PendingTasks); i++ {
-	//	select {
-	//	case task := <-o.Store.PendingTasks:
-	//		tasks = append(tasks, task)
-	//		o.Store.PendingTasks <- task // Возвращаем задачу в очередь
-	//	default:
-	//		break
-	//	}
-	//}
-	for _, task := range o.Store.Tasks {
-		if !task.Completed { // Показываем только незавершённые задачи
-			tasks = append(tasks, task)
-		}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Agents []models.AgentInfo `json:"agents"`
+	}{Agents: o.Store.GetAgents()})
+}
+
+// Возвращает статистику кэша результатов
+func (o *Orchestrator) handleGetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	o.Store.Mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o.cache.Stats())
+}
+
+func (o *Orchestrator) handleGetPendingTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tasks := o.Store.GetPendingTasks()
 
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(struct {
-		Tasks []models.Task `json:"tasks"`
+		Tasks []store.PendingTask `json:"tasks"`
 	}{Tasks: tasks})
 	if err != nil {
 		log.Printf("Ошибка сериализации задач: %v", err)
@@ -208,3 +379,23 @@ func (o *Orchestrator) handleGetPendingTasks(w http.ResponseWriter, r *http.Requ
 		return
 	}
 }
+
+// Определяет владельца запроса: пользователь из заголовка X-User-ID, иначе IP клиента
+func requestOwner(r *http.Request) string {
+	if user := requestUser(r); user != "" {
+		return user
+	}
+	return clientIP(r)
+}
+
+func requestUser(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("X-User-ID"))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
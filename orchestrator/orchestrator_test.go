@@ -0,0 +1,234 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NieR8/myProject/internal/cache"
+	"github.com/NieR8/myProject/internal/env"
+	"github.com/NieR8/myProject/models"
+)
+
+// Отправляет запрос оркестратору без сетевого сервера
+func serve(o *Orchestrator, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	o.routes().ServeHTTP(w, r)
+	return w
+}
+
+func testConfig() env.Config {
+	config := env.Default()
+	config.RateLimitIPPerMin, config.RateLimitUserPerMin, config.DailyQuota = 0, 0, 0
+	return config
+}
+
+func TestIdempotencyKeyReplaysResponse(t *testing.T) {
+	o := NewOrchestrator(testConfig())
+	key := map[string]string{"Idempotency-Key": "k1"}
+
+	first := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+2"}`, key)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request: %d %s", first.Code, first.Body)
+	}
+	again := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+2"}`, key)
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay = %d %q (replayed %q), want %q", again.Code, again.Body, again.Header().Get("Idempotent-Replayed"), first.Body)
+	}
+	if n := len(o.Store.GetAllExpressions()); n != 1 {
+		t.Errorf("%d expressions after replay, want 1", n)
+	}
+
+	if conflict := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "3+3"}`, key); conflict.Code != http.StatusConflict {
+		t.Errorf("same key with another body: %d, want 409", conflict.Code)
+	}
+	other := map[string]string{"Idempotency-Key": "k1", "X-User-ID": "bob"}
+	if created := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "3+3"}`, other); created.Code != http.StatusCreated {
+		t.Errorf("same key from another user: %d, want 201", created.Code)
+	}
+}
+
+func TestRateLimitRejectsWithRetryAfter(t *testing.T) {
+	config := testConfig()
+	config.RateLimitIPPerMin, config.RateLimitIPBurst = 60, 1
+	o := NewOrchestrator(config)
+
+	first := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+2"}`, nil)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request: %d %s", first.Code, first.Body)
+	}
+	for _, header := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+		if first.Header().Get(header) == "" {
+			t.Errorf("accepted request has no %s", header)
+		}
+	}
+
+	limited := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+2"}`, nil)
+	if limited.Code != http.StatusTooManyRequests || limited.Header().Get("Retry-After") != "1" || limited.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("second request: %d, Retry-After %q, remaining %q", limited.Code, limited.Header().Get("Retry-After"), limited.Header().Get("X-RateLimit-Remaining"))
+	}
+	if get := serve(o, http.MethodGet, "/api/v1/expressions", "", nil); get.Code != http.StatusOK {
+		t.Errorf("GET is rate limited: %d", get.Code)
+	}
+}
+
+func TestQuotaCountsOnlyAcceptedExpressions(t *testing.T) {
+	config := testConfig()
+	config.DailyQuota = 1
+	config.RateLimitIPPerMin, config.RateLimitIPBurst = 60, 10
+	o := NewOrchestrator(config)
+
+	if invalid := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+"}`, nil); invalid.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid expression: %d", invalid.Code)
+	}
+	if accepted := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+2"}`, nil); accepted.Code != http.StatusCreated {
+		t.Fatalf("invalid expression used up the quota: %d", accepted.Code)
+	}
+	// Смена X-User-ID не обходит квоту IP
+	if over := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+2"}`, map[string]string{"X-User-ID": "eve"}); over.Code != http.StatusTooManyRequests {
+		t.Errorf("request over the IP quota: %d, want 429", over.Code)
+	}
+	// Отклонённый квотой запрос не тратит лимит частоты
+	if n := o.ipLimiter.Allow("192.0.2.1", time.Now()).Remaining; n != 7 {
+		t.Errorf("IP limiter remaining = %d, want 7", n)
+	}
+}
+
+func TestDrainRejectsNewExpressions(t *testing.T) {
+	o := NewOrchestrator(testConfig())
+	o.draining.Store(true)
+
+	w := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+2"}`, nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("POST while draining: %d, Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if get := serve(o, http.MethodGet, "/api/v1/expressions", "", nil); get.Code != http.StatusOK {
+		t.Errorf("GET while draining: %d, want 200", get.Code)
+	}
+}
+
+func TestLegacyStatusCodes(t *testing.T) {
+	config := testConfig()
+	config.LegacyStatusCodes = true
+	o := NewOrchestrator(config)
+	serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "2+"}`, nil)
+
+	var resp struct {
+		Expression struct {
+			Status int `json:"status"`
+			Events []struct {
+				Status int `json:"status"`
+			} `json:"events"`
+		} `json:"expression"`
+	}
+	w := serve(o, http.MethodGet, "/api/v1/expressions/1", "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("status is not a legacy code: %v, %s", err, w.Body)
+	}
+	if resp.Expression.Status != 3 || len(resp.Expression.Events) == 0 {
+		t.Errorf("expression = %+v, want invalid (3) with events", resp.Expression)
+	}
+}
+
+func TestWebInterface(t *testing.T) {
+	o := NewOrchestrator(testConfig())
+
+	tests := []struct {
+		path        string
+		code        int
+		contentType string
+		body        string
+	}{
+		{"/", http.StatusOK, "text/html", "<title>Калькулятор</title>"},
+		{"/index.html", http.StatusMovedPermanently, "", ""}, // Файловый сервер отдаёт индекс только по /
+		{"/app.js", http.StatusOK, "javascript", "/api/v1/"},
+		{"/style.css", http.StatusOK, "text/css", ""},
+		{"/missing.js", http.StatusNotFound, "text/plain", ""},
+		{"/api/v1/unknown", http.StatusNotFound, "application/json", `"error":"unknown endpoint GET /api/v1/unknown"`},
+		{"/api/v2/expressions", http.StatusNotFound, "application/json", `"error"`},
+		{"/api/v1/expressions", http.StatusOK, "application/json", `"expressions"`},
+	}
+	for _, tt := range tests {
+		w := serve(o, http.MethodGet, tt.path, "", nil)
+		if w.Code != tt.code {
+			t.Errorf("GET %s: %d, want %d", tt.path, w.Code, tt.code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.Contains(ct, tt.contentType) {
+			t.Errorf("GET %s: Content-Type %q, want %q", tt.path, ct, tt.contentType)
+		}
+		if !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("GET %s: body does not contain %q:\n%.200s", tt.path, tt.body, w.Body)
+		}
+	}
+}
+
+func TestCacheStatsCountOneLookupPerExpression(t *testing.T) {
+	config := testConfig()
+	config.FoldConstants = false
+	o := NewOrchestrator(config)
+
+	if w := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "(1+2)*(3+4)"}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("first submission: %d %s", w.Code, w.Body)
+	}
+	for tasks := o.Store.LeaseTasks("", 10); len(tasks) > 0; tasks = o.Store.LeaseTasks("", 10) {
+		for _, task := range tasks {
+			a, _ := strconv.ParseFloat(task.Arg1, 64)
+			b, _ := strconv.ParseFloat(task.Arg2, 64)
+			value := a + b
+			if task.Operation == "*" {
+				value = 3 * 7
+			}
+			o.Store.UpdateTask(models.Result{TaskID: task.ID, Value: value})
+		}
+	}
+	if w := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "(1+2)*(3+4)"}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("second submission: %d %s", w.Code, w.Body)
+	}
+	if expr, _ := o.Store.GetExpression(2); !expr.Cached || expr.Result != 21 {
+		t.Errorf("second expression = %+v, want cached 21", expr)
+	}
+
+	var stats cache.Stats
+	w := serve(o, http.MethodGet, "/api/v1/cache/stats", "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("GET /api/v1/cache/stats: %d %s", w.Code, w.Body)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Size != 3 || stats.HitRate != 0.5 {
+		t.Errorf("cache stats = %+v, want 1 hit, 1 miss and 3 subtrees", stats)
+	}
+}
+
+func TestIntegerOperationsRejectFractionalLiterals(t *testing.T) {
+	for _, fold := range []bool{true, false} {
+		config := testConfig()
+		config.FoldConstants = fold
+		o := NewOrchestrator(config)
+
+		for _, expression := range []string{"7.5 % 2", "(1+2) // 0.5", "7 // 0"} {
+			w := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "`+expression+`"}`, nil)
+			if w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("fold %v, %s: %d %s, want 422", fold, expression, w.Code, w.Body)
+			}
+		}
+		if w := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "7.5 % 2"}`, nil); !strings.Contains(w.Body.String(), "operands must be integers, got 7.5") {
+			t.Errorf("fold %v: response %q does not name the operand", fold, w.Body)
+		}
+		if w := serve(o, http.MethodPost, "/api/v1/calculate", `{"expression": "7 % 2 + 0.5"}`, nil); w.Code != http.StatusCreated {
+			t.Errorf("fold %v, 7 %% 2 + 0.5: %d %s, want 201", fold, w.Code, w.Body)
+		}
+		for _, expr := range o.Store.GetAllExpressions() {
+			if expr.Name != "7 % 2 + 0.5" && (expr.Status != models.StatusInvalid || expr.ErrorDetails == nil || expr.ErrorDetails.Code != "parse_error") {
+				t.Errorf("fold %v, %s: status %s, details %+v, want invalid parse_error", fold, expr.Name, expr.Status, expr.ErrorDetails)
+			}
+		}
+	}
+}
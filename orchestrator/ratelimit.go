@@ -0,0 +1,86 @@
+package orchestrator
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NieR8/myProject/internal/ratelimit"
+)
+
+// Лимит, который списывается на запрос и может быть возвращён
+type limit interface {
+	Allow(key string, now time.Time) ratelimit.Decision
+	Refund(key string, now time.Time)
+}
+
+// Списание лимита по ключу
+type charge struct {
+	limit limit
+	key   string
+}
+
+// Ограничивает частоту запросов с IP и от пользователя, а также дневную квоту
+// IP и пользователя: X-User-ID клиент задаёт сам, поэтому квота по нему одна
+// не спасает от смены заголовка. Отвечает 429 с Retry-After, если хотя бы один
+// лимит исчерпан; тогда ни один лимит не списывается. Квота засчитывается
+// только за принятые выражения
+func (o *Orchestrator) rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		now := time.Now()
+		ip, user := clientIP(r), requestUser(r)
+		charges := []charge{{o.ipLimiter, ip}}
+		quotas := []charge{{o.quota, "ip:" + ip}}
+		if user != "" {
+			charges = append(charges, charge{o.userLimiter, user})
+			quotas = append(quotas, charge{o.quota, "user:" + user})
+		}
+		charges = append(charges, quotas...)
+
+		var tightest *ratelimit.Decision
+		for i, c := range charges {
+			decision := c.limit.Allow(c.key, now)
+			if decision.Limit < 0 {
+				continue // Лимит отключён в конфигурации
+			}
+			if !decision.Allowed {
+				for _, charged := range charges[:i] {
+					charged.limit.Refund(charged.key, now)
+				}
+				setRateLimitHeaders(w, decision)
+				retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				log.Printf("Запрос от %s отклонён лимитом, повтор через %d с", requestOwner(r), retryAfter)
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			if tightest == nil || decision.Remaining < tightest.Remaining {
+				tightest = &decision
+			}
+		}
+		if tightest != nil {
+			setRateLimitHeaders(w, *tightest)
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if rec.status != http.StatusCreated { // Невалидное выражение квоту не тратит
+			for _, c := range quotas {
+				c.limit.Refund(c.key, now)
+			}
+		}
+	}
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, d ratelimit.Decision) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(d.Reset.Unix(), 10))
+}
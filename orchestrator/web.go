@@ -0,0 +1,30 @@
+package orchestrator
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+)
+
+// Веб-интерфейс: статические файлы встроены в бинарник и не требуют интернета
+//
+//go:embed web
+var webFiles embed.FS
+
+func webHandler() http.Handler {
+	root, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err) // Каталог встроен при сборке, ошибки быть не может
+	}
+	return http.FileServer(http.FS(root))
+}
+
+// Неизвестный путь API: клиенту нужен JSON 404, а не страница веб-интерфейса
+func handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{"unknown endpoint " + r.Method + " " + r.URL.Path})
+}
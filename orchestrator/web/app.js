@@ -0,0 +1,232 @@
+"use strict";
+
+// Веб-интерфейс калькулятора: опрашивает публичное API оркестратора и рисует состояние
+
+const POLL_INTERVAL_MS = 1000;
+// Прежние числовые коды статусов, если оркестратор запущен с LEGACY_STATUS_CODES
+const STATUS_NAMES = {0: "completed", 1: "running", 2: "pending", 3: "invalid", 4: "cancelled"};
+
+let selectedId = null;
+
+function $(id) {
+    return document.getElementById(id);
+}
+
+function el(tag, props, children) {
+    const node = document.createElement(tag);
+    Object.assign(node, props || {});
+    for (const child of children || []) {
+        node.append(child);
+    }
+    return node;
+}
+
+function statusName(status) {
+    return typeof status === "number" ? (STATUS_NAMES[status] || String(status)) : status;
+}
+
+function badge(text, cls) {
+    return el("span", {className: "badge " + cls, textContent: text});
+}
+
+function isFinal(status) {
+    return ["completed", "invalid", "failed", "cancelled", "timed_out"].includes(statusName(status));
+}
+
+function formatNumber(value) {
+    const num = Number(value);
+    return Number.isFinite(num) ? String(num) : value;
+}
+
+async function api(method, path, body) {
+    const options = {method, headers: {}};
+    if (body !== undefined) {
+        options.headers["Content-Type"] = "application/json";
+        options.body = JSON.stringify(body);
+    }
+    const resp = await fetch(path, options);
+    const text = await resp.text();
+    if (!resp.ok) {
+        throw new Error(text.trim() || resp.statusText);
+    }
+    return text ? JSON.parse(text) : null;
+}
+
+function setConnection(ok) {
+    const node = $("connection");
+    node.textContent = ok ? "на связи" : "нет связи с оркестратором";
+    node.className = "badge " + (ok ? "online" : "offline");
+}
+
+async function submitExpression(event) {
+    event.preventDefault();
+    const message = $("submit-message");
+    const body = {
+        expression: $("expression").value,
+        priority: Number($("priority").value),
+        no_cache: $("no-cache").checked,
+    };
+    try {
+        const resp = await api("POST", "/api/v1/calculate", body);
+        message.className = "message";
+        message.textContent = "Выражение принято, ID " + resp.id;
+        $("expression").value = "";
+        selectedId = resp.id;
+        await refresh();
+    } catch (err) {
+        message.className = "message error";
+        message.textContent = err.message;
+    }
+}
+
+function renderExpressions(expressions) {
+    expressions.sort((a, b) => b.id - a.id);
+    const rows = expressions.map((expr) => {
+        const status = statusName(expr.status);
+        const row = el("tr", {className: "clickable" + (expr.id === selectedId ? " selected" : "")}, [
+            el("td", {textContent: expr.id}),
+            el("td", {textContent: expr.name}),
+            el("td", {}, [badge(status, "status-" + status)]),
+            el("td", {textContent: status === "completed" ? formatNumber(expr.result) : (expr.error || "")}),
+            el("td", {textContent: expr.priority || 0}),
+        ]);
+        row.addEventListener("click", () => {
+            selectedId = expr.id;
+            refresh();
+        });
+        return row;
+    });
+    $("expressions").replaceChildren(...rows);
+}
+
+// Рисует дерево операций псевдографикой
+function renderTree(node, prefix, last, root) {
+    if (!node) {
+        return "";
+    }
+    let branch = "";
+    let next = "";
+    if (!root) {
+        branch = last ? "└── " : "├── ";
+        next = last ? "    " : "│   ";
+    }
+    let text = prefix + branch + formatNumber(node.value) + "\n";
+    if (node.left || node.right) {
+        text += renderTree(node.left, prefix + next, false, false);
+        text += renderTree(node.right, prefix + next, true, false);
+    }
+    return text;
+}
+
+function operand(arg, value) {
+    return value === undefined || String(value) === formatNumber(arg) ? formatNumber(arg) : arg + " (" + value + ")";
+}
+
+async function loadTasks(id) {
+    const resp = await api("GET", "/api/v1/expressions/" + id + "/tasks");
+    return (resp.tasks || []).map((task) => ({
+        id: task.id,
+        operation: operand(task.arg1, task.arg1_value) + " " + task.operation + " " + operand(task.arg2, task.arg2_value),
+        state: task.state,
+        agent: task.agent || "",
+        duration: task.duration_ms ? task.duration_ms + " мс" : "",
+    }));
+}
+
+async function renderDetails() {
+    if (selectedId === null) {
+        $("details").hidden = true;
+        return;
+    }
+    const resp = await api("GET", "/api/v1/expressions/" + selectedId);
+    const expr = resp.expression;
+    const status = statusName(expr.status);
+    $("details").hidden = false;
+    $("details-id").textContent = "#" + expr.id + ": " + expr.name;
+
+    let summary = "Статус: " + status + ", готово " + (expr.progress || 0) + "%";
+    if (status === "completed") {
+        summary += ", результат: " + formatNumber(expr.result);
+    }
+    if (expr.error) {
+        summary += ", ошибка: " + expr.error;
+    }
+    if (expr.predicted_remaining_ms) {
+        summary += ", осталось примерно " + expr.predicted_remaining_ms + " мс";
+    }
+    if (expr.duration_ms) {
+        summary += ", " + (isFinal(expr.status) ? "считалось " : "прошло ") + expr.duration_ms + " мс";
+    }
+    if (expr.cached) {
+        summary += " (из кэша)";
+    }
+    $("details-summary").textContent = summary;
+    $("tree").textContent = renderTree(expr.node, "", true, true);
+    $("graph-link").href = "/api/v1/expressions/" + expr.id + "/graph?format=svg";
+
+    const tasks = await loadTasks(expr.id);
+    $("tasks").replaceChildren(...tasks.map((task) => el("tr", {}, [
+        el("td", {textContent: task.id}),
+        el("td", {textContent: task.operation}),
+        el("td", {}, [badge(task.state, "status-" + task.state)]),
+        el("td", {textContent: task.agent}),
+        el("td", {textContent: task.duration}),
+    ])));
+    $("events").replaceChildren(...(expr.events || []).map((event) => el("tr", {}, [
+        el("td", {textContent: new Date(event.time).toLocaleTimeString()}),
+        el("td", {textContent: event.type}),
+        el("td", {textContent: event.actor || ""}),
+        el("td", {textContent: event.detail || ""}),
+    ])));
+    $("cancel").hidden = isFinal(expr.status);
+}
+
+function renderAgents(agents) {
+    $("agents").replaceChildren(...agents.map((agent) => el("tr", {}, [
+        el("td", {textContent: agent.id}),
+        el("td", {}, [agent.online ? badge("да", "online") : badge("нет", "offline")]),
+        el("td", {textContent: (agent.in_flight || []).length}),
+        el("td", {textContent: agent.completed}),
+        el("td", {textContent: new Date(agent.last_seen).toLocaleTimeString()}),
+    ])));
+}
+
+async function cancelSelected() {
+    if (selectedId === null) {
+        return;
+    }
+    try {
+        await api("DELETE", "/api/v1/expressions/" + selectedId);
+    } catch (err) {
+        $("submit-message").className = "message error";
+        $("submit-message").textContent = err.message;
+    }
+    await refresh();
+}
+
+async function refresh() {
+    try {
+        const [expressions, agents] = await Promise.all([
+            api("GET", "/api/v1/expressions"),
+            api("GET", "/api/v1/agents"),
+        ]);
+        renderExpressions(expressions.expressions || []);
+        renderAgents(agents.agents || []);
+        await renderDetails();
+        setConnection(true);
+    } catch (err) {
+        setConnection(false);
+    }
+}
+
+function init() {
+    for (let p = 0; p <= 9; p++) {
+        $("priority").append(el("option", {value: p, textContent: p}));
+    }
+    $("submit-form").addEventListener("submit", submitExpression);
+    $("cancel").addEventListener("click", cancelSelected);
+    refresh();
+    setInterval(refresh, POLL_INTERVAL_MS);
+}
+
+init();
@@ -0,0 +1,138 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NieR8/myProject/models"
+)
+
+// Клиент публичного API оркестратора
+type Client struct {
+	BaseURL string // Например, http://localhost:8080
+	User    string // Передаётся в заголовке X-User-ID, если не пустой
+	HTTP    *http.Client
+}
+
+// Ошибка, которую вернул оркестратор
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Параметры отправки выражения
+type SubmitOptions struct {
+	Priority       int
+	NoCache        bool
+	Decimal        bool // Считать в десятичной арифметике
+	IdempotencyKey string
+}
+
+func New(baseURL string) *Client {
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "http://" + baseURL
+	}
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Отправляет выражение и возвращает его ID
+func (c *Client) Submit(expression string, opts SubmitOptions) (int, error) {
+	body, err := json.Marshal(struct {
+		Expression string `json:"expression"`
+		Priority   int    `json:"priority,omitempty"`
+		NoCache    bool   `json:"no_cache,omitempty"`
+		Decimal    bool   `json:"decimal,omitempty"`
+	}{expression, opts.Priority, opts.NoCache, opts.Decimal})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.BaseURL+"/api/v1/calculate", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if opts.IdempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", opts.IdempotencyKey)
+	}
+
+	var resp struct {
+		ID int `json:"id"`
+	}
+	if err := c.do(req, http.StatusCreated, &resp); err != nil {
+		return 0, err
+	}
+	return resp.ID, nil
+}
+
+// Возвращает выражение по ID
+func (c *Client) Get(id int) (models.Expression, error) {
+	var resp struct {
+		Expression models.Expression `json:"expression"`
+	}
+	err := c.call(http.MethodGet, fmt.Sprintf("/api/v1/expressions/%d", id), &resp)
+	return resp.Expression, err
+}
+
+// Возвращает все выражения
+func (c *Client) List() ([]models.Expression, error) {
+	var resp struct {
+		Expressions []models.Expression `json:"expressions"`
+	}
+	err := c.call(http.MethodGet, "/api/v1/expressions", &resp)
+	return resp.Expressions, err
+}
+
+// Отменяет выражение
+func (c *Client) Cancel(id int) (models.Expression, error) {
+	var resp struct {
+		Expression models.Expression `json:"expression"`
+	}
+	err := c.call(http.MethodDelete, fmt.Sprintf("/api/v1/expressions/%d", id), &resp)
+	return resp.Expression, err
+}
+
+// Возвращает агентов, известных оркестратору
+func (c *Client) Agents() ([]models.AgentInfo, error) {
+	var resp struct {
+		Agents []models.AgentInfo `json:"agents"`
+	}
+	err := c.call(http.MethodGet, "/api/v1/agents", &resp)
+	return resp.Agents, err
+}
+
+func (c *Client) call(method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, http.StatusOK, out)
+}
+
+func (c *Client) do(req *http.Request, want int, out interface{}) error {
+	if c.User != "" {
+		req.Header.Set("X-User-ID", c.User)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
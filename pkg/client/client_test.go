@@ -0,0 +1,116 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NieR8/myProject/models"
+)
+
+func TestNewNormalizesBaseURL(t *testing.T) {
+	tests := map[string]string{
+		"localhost:8080":         "http://localhost:8080",
+		"http://localhost:8080/": "http://localhost:8080",
+		"https://calc.example/":  "https://calc.example",
+	}
+	for in, want := range tests {
+		if got := New(in).BaseURL; got != want {
+			t.Errorf("New(%q).BaseURL = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	var got *http.Request
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, body = r, nil
+		json.NewDecoder(r.Body).Decode(&body)
+		if body["expression"] == "2+" {
+			http.Error(w, "Invalid expression: unexpected end", http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id": 42}`)
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	c.User = "alice"
+	id, err := c.Submit("2+2", SubmitOptions{Priority: 3, NoCache: true, Decimal: true, IdempotencyKey: "k1"})
+	if err != nil || id != 42 {
+		t.Fatalf("Submit() = %d, %v, want 42", id, err)
+	}
+	if got.Method != http.MethodPost || got.URL.Path != "/api/v1/calculate" {
+		t.Errorf("request %s %s, want POST /api/v1/calculate", got.Method, got.URL.Path)
+	}
+	if got.Header.Get("X-User-ID") != "alice" || got.Header.Get("Idempotency-Key") != "k1" {
+		t.Errorf("headers %v, want X-User-ID and Idempotency-Key", got.Header)
+	}
+	want := map[string]interface{}{"expression": "2+2", "priority": 3.0, "no_cache": true, "decimal": true}
+	for key, value := range want {
+		if body[key] != value {
+			t.Errorf("body[%q] = %v, want %v", key, body[key], value)
+		}
+	}
+
+	// Необязательные поля не передаются, если не заданы
+	if _, err := c.Submit("1+1", SubmitOptions{}); err != nil {
+		t.Fatalf("Submit() error: %v", err)
+	}
+	if len(body) != 1 || got.Header.Get("Idempotency-Key") != "" {
+		t.Errorf("body %v, Idempotency-Key %q, want only the expression", body, got.Header.Get("Idempotency-Key"))
+	}
+
+	_, err = c.Submit("2+", SubmitOptions{})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Message != "Invalid expression: unexpected end" {
+		t.Errorf("Submit(2+) error = %#v, want APIError 422 with the message", err)
+	}
+}
+
+func TestReadEndpoints(t *testing.T) {
+	expr := `{"id": 7, "name": "2+2", "status": "completed", "result": 4}`
+	routes := map[string]string{
+		"GET /api/v1/expressions/7":    `{"expression": ` + expr + `}`,
+		"DELETE /api/v1/expressions/7": `{"expression": {"id": 7, "name": "2+2", "status": "cancelled"}}`,
+		"GET /api/v1/expressions":      `{"expressions": [` + expr + `]}`,
+		"GET /api/v1/agents":           `{"agents": [{"id": "a1", "online": true, "in_flight": ["task-expr-7-0"], "completed": 3}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			http.Error(w, "Expression not found", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, response)
+	}))
+	defer server.Close()
+	c := New(server.URL)
+
+	if got, err := c.Get(7); err != nil || got.Id != 7 || got.Status != models.StatusCompleted || got.Result != 4 {
+		t.Errorf("Get(7) = %+v, %v", got, err)
+	}
+	if got, err := c.Cancel(7); err != nil || got.Status != models.StatusCancelled {
+		t.Errorf("Cancel(7) = %+v, %v", got, err)
+	}
+	if got, err := c.List(); err != nil || len(got) != 1 || got[0].Name != "2+2" {
+		t.Errorf("List() = %+v, %v", got, err)
+	}
+	if got, err := c.Agents(); err != nil || len(got) != 1 || got[0].ID != "a1" || !got[0].Online || got[0].Completed != 3 {
+		t.Errorf("Agents() = %+v, %v", got, err)
+	}
+
+	_, err := c.Get(8)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Get(8) error = %v, want APIError 404", err)
+	}
+	if want := "404 Not Found: Expression not found"; err.Error() != want {
+		t.Errorf("Get(8) error = %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,114 @@
+package ops
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Встроенные операции. Приоритеты от слабого к сильному: || && (== !=)
+// (< <= > >=) (+ -) (* / // %)
+func init() {
+	MustRegister(Operation{
+		Symbol: "+", Arity: 2, Precedence: 5, Commutative: true, DefaultCost: 200 * time.Millisecond,
+		Eval: func(args ...float64) (float64, error) { return args[0] + args[1], nil },
+	})
+	MustRegister(Operation{
+		Symbol: "-", Arity: 2, Precedence: 5, DefaultCost: 150 * time.Millisecond,
+		Eval: func(args ...float64) (float64, error) { return args[0] - args[1], nil },
+	})
+	MustRegister(Operation{
+		Symbol: "*", Arity: 2, Precedence: 6, Commutative: true, DefaultCost: 100 * time.Millisecond,
+		Eval: func(args ...float64) (float64, error) { return args[0] * args[1], nil },
+	})
+	MustRegister(Operation{
+		Symbol: "/", Arity: 2, Precedence: 6, NonZeroDivisor: true, DefaultCost: 250 * time.Millisecond,
+		Eval: func(args ...float64) (float64, error) {
+			if args[1] == 0 {
+				return 0, ErrDivisionByZero
+			}
+			return args[0] / args[1], nil
+		},
+	})
+	MustRegister(Operation{
+		Symbol: "//", Arity: 2, Precedence: 6, NonZeroDivisor: true, IntegerOperands: true, DefaultCost: 250 * time.Millisecond,
+		Eval: func(args ...float64) (float64, error) {
+			q, _, err := floorDivMod(args[0], args[1])
+			return q, err
+		},
+	})
+	MustRegister(Operation{
+		Symbol: "%", Arity: 2, Precedence: 6, NonZeroDivisor: true, IntegerOperands: true, DefaultCost: 250 * time.Millisecond,
+		Eval: func(args ...float64) (float64, error) {
+			_, r, err := floorDivMod(args[0], args[1])
+			return r, err
+		},
+	})
+
+	// Сравнения и логические операции дают 1 (истина) или 0 (ложь); любое
+	// ненулевое число считается истиной. && и || вычисляют оба операнда:
+	// чтобы не считать ненужную ветку, используйте условие ?: или if
+	compare := []struct {
+		symbol     string
+		precedence int
+		commute    bool
+		test       func(a, b float64) bool
+	}{
+		{"<", 4, false, func(a, b float64) bool { return a < b }},
+		{"<=", 4, false, func(a, b float64) bool { return a <= b }},
+		{">", 4, false, func(a, b float64) bool { return a > b }},
+		{">=", 4, false, func(a, b float64) bool { return a >= b }},
+		{"==", 3, true, func(a, b float64) bool { return a == b }},
+		{"!=", 3, true, func(a, b float64) bool { return a != b }},
+		{"&&", 2, true, func(a, b float64) bool { return a != 0 && b != 0 }},
+		{"||", 1, true, func(a, b float64) bool { return a != 0 || b != 0 }},
+	}
+	for _, c := range compare {
+		test := c.test
+		MustRegister(Operation{
+			Symbol: c.symbol, Arity: 2, Precedence: c.precedence, Commutative: c.commute, DefaultCost: 50 * time.Millisecond,
+			Eval: func(args ...float64) (float64, error) { return truth(test(args[0], args[1])), nil },
+		})
+	}
+}
+
+// Значение логического результата: 1 для истины, 0 для лжи
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Наибольшее целое, которое float64 хранит точно
+const maxExactInt = 1 << 53
+
+// Проверяет, что x - целое число, которое float64 хранит точно; ошибка
+// оборачивает ErrNotInteger
+func CheckInteger(x float64) error {
+	if x != math.Trunc(x) || math.Abs(x) > maxExactInt {
+		return fmt.Errorf("%w, got %v", ErrNotInteger, x)
+	}
+	return nil
+}
+
+// Целочисленное деление с округлением вниз и остаток с тем же знаком, что у
+// делителя: a = b*q + r, 0 <= |r| < |b|. Например, -7 // 2 = -4 и -7 % 2 = 1,
+// 7 // -2 = -4 и 7 % -2 = -1. Операнды должны быть целыми числами
+func floorDivMod(a, b float64) (float64, float64, error) {
+	for _, x := range []float64{a, b} {
+		if err := CheckInteger(x); err != nil {
+			return 0, 0, err
+		}
+	}
+	if b == 0 {
+		return 0, 0, ErrDivisionByZero
+	}
+	x, y := int64(a), int64(b)
+	q, r := x/y, x%y
+	if r != 0 && (r < 0) != (y < 0) {
+		q--
+		r += y
+	}
+	return float64(q), float64(r), nil
+}
@@ -0,0 +1,134 @@
+// Реестр операций, общий для парсера, оркестратора и агента. Встроенные
+// операции (+ - * / // %, сравнения и && ||) регистрируются при загрузке пакета; сторонние операции
+// регистрируются через Register при запуске, до разбора первого выражения
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ассоциативность оператора: как группируются операторы одного приоритета
+type Associativity int
+
+const (
+	LeftAssoc  Associativity = iota // a-b-c = (a-b)-c
+	RightAssoc                      // a^b^c = a^(b^c)
+)
+
+// Операция выражения
+type Operation struct {
+	Symbol          string        // Запись в выражении, например "+" или "//"
+	Arity           int           // Число операндов; задачи агентов двухместные, поэтому пока только 2
+	Precedence      int           // Чем больше, тем раньше выполняется
+	Associativity   Associativity // Для операторов одного приоритета
+	Commutative     bool          // a op b = b op a: такие поддеревья делят одну задачу и запись в кэше
+	NonZeroDivisor  bool          // Правый операнд не может быть нулём: BuildTasks отклоняет x op 0 сразу
+	IntegerOperands bool          // Операнды должны быть целыми: BuildTasks отклоняет дробное число в записи сразу
+	Eval            Evaluator     // Считает значение; ошибка означает, что повтор не поможет
+	DefaultCost     time.Duration // Время операции у агента, если конфигурация не задаёт другое
+}
+
+// Считает операцию над операндами; len(args) равно Arity
+type Evaluator func(args ...float64) (float64, error)
+
+var (
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrNotInteger     = errors.New("operands must be integers")
+
+	mu       sync.RWMutex
+	registry = make(map[string]Operation)
+)
+
+// Добавляет операцию в реестр. Вызывается при запуске, до разбора выражений:
+// уже построенные задачи и деревья не перепроверяются
+func Register(op Operation) error {
+	switch {
+	case op.Symbol == "" || strings.ContainsAny(op.Symbol, "0123456789.(),?: \t"):
+		return fmt.Errorf("operation symbol %q must not be empty or contain digits, dots, parentheses, commas, ?, : or spaces", op.Symbol)
+	case op.Arity != 2:
+		return fmt.Errorf("operation %s: arity %d is not supported, tasks have two operands", op.Symbol, op.Arity)
+	case op.Eval == nil:
+		return fmt.Errorf("operation %s: no evaluator", op.Symbol)
+	case op.Precedence < 1:
+		return fmt.Errorf("operation %s: precedence must be at least 1", op.Symbol)
+	case op.DefaultCost < 0:
+		return fmt.Errorf("operation %s: negative default cost", op.Symbol)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := registry[op.Symbol]; exists {
+		return fmt.Errorf("operation %s is already registered", op.Symbol)
+	}
+	registry[op.Symbol] = op
+	return nil
+}
+
+// То же, что Register, но паникует при ошибке; для регистрации в init
+func MustRegister(op Operation) {
+	if err := Register(op); err != nil {
+		panic(err)
+	}
+}
+
+// Возвращает операцию по её записи
+func Lookup(symbol string) (Operation, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	op, ok := registry[symbol]
+	return op, ok
+}
+
+// Является ли токен записью зарегистрированной операции
+func IsOperator(token string) bool {
+	_, ok := Lookup(token)
+	return ok
+}
+
+// Все операции, отсортированные по записи
+func All() []Operation {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := make([]Operation, 0, len(registry))
+	for _, op := range registry {
+		all = append(all, op)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Symbol < all[j].Symbol })
+	return all
+}
+
+// Записи всех операций, отсортированные по записи
+func Symbols() []string {
+	var symbols []string
+	for _, op := range All() {
+		symbols = append(symbols, op.Symbol)
+	}
+	return symbols
+}
+
+// Самая длинная запись операции, с которой начинается s; пусто - ни одной
+func Match(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	best := ""
+	for symbol := range registry {
+		if len(symbol) > len(best) && strings.HasPrefix(s, symbol) {
+			best = symbol
+		}
+	}
+	return best
+}
+
+// Считает операцию над двумя числами
+func Apply(symbol string, a, b float64) (float64, error) {
+	op, ok := Lookup(symbol)
+	if !ok {
+		return 0, fmt.Errorf("unsupported operation: %s", symbol)
+	}
+	return op.Eval(a, b)
+}
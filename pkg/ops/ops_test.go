@@ -0,0 +1,89 @@
+package ops
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisterValidatesOperations(t *testing.T) {
+	eval := func(args ...float64) (float64, error) { return args[0], nil }
+	for _, op := range []Operation{
+		{Symbol: "+", Arity: 2, Precedence: 1, Eval: eval},   // Уже есть
+		{Symbol: "neg", Arity: 1, Precedence: 3, Eval: eval}, // Задачи двухместные
+		{Symbol: "(", Arity: 2, Precedence: 1, Eval: eval},   // Скобка
+		{Symbol: "max", Arity: 2, Precedence: 1},             // Нечем считать
+		{Symbol: "max", Arity: 2, Precedence: 0, Eval: eval}, // Приоритет ниже скобок
+	} {
+		if err := Register(op); err == nil {
+			t.Errorf("Register(%q) accepted an invalid operation", op.Symbol)
+		}
+	}
+
+	if got, err := Apply("/", 1, 0); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("Apply(1/0) = %v, %v, want ErrDivisionByZero", got, err)
+	}
+	if _, err := Apply("?", 1, 2); err == nil {
+		t.Error("Apply() accepted an unknown operation")
+	}
+	if got := Match("*3"); got != "*" {
+		t.Errorf("Match(*3) = %q, want *", got)
+	}
+}
+
+func TestFloorDivisionAndModulo(t *testing.T) {
+	tests := []struct {
+		a, b      float64
+		quot, rem float64
+		wantErr   error
+	}{
+		{7, 2, 3, 1, nil},
+		{-7, 2, -4, 1, nil},
+		{7, -2, -4, -1, nil},
+		{-7, -2, 3, -1, nil},
+		{6, 3, 2, 0, nil},
+		{7, 0, 0, 0, ErrDivisionByZero},
+		{7.5, 2, 0, 0, ErrNotInteger},
+		{1 << 60, 3, 0, 0, ErrNotInteger}, // Не представимо точно
+	}
+	for _, tt := range tests {
+		quot, err := Apply("//", tt.a, tt.b)
+		rem, remErr := Apply("%", tt.a, tt.b)
+		if !errors.Is(err, tt.wantErr) || !errors.Is(remErr, tt.wantErr) {
+			t.Errorf("%v // %v: errors %v, %v, want %v", tt.a, tt.b, err, remErr, tt.wantErr)
+			continue
+		}
+		if tt.wantErr == nil && (quot != tt.quot || rem != tt.rem) {
+			t.Errorf("%v // %v = %v, %v %% %v = %v, want %v and %v", tt.a, tt.b, quot, tt.a, tt.b, rem, tt.quot, tt.rem)
+		}
+	}
+}
+
+func TestComparisonAndLogic(t *testing.T) {
+	tests := []struct {
+		op   string
+		a, b float64
+		want float64
+	}{
+		{"<", 1, 2, 1},
+		{"<=", 2, 2, 1},
+		{">", 1, 2, 0},
+		{">=", 1, 2, 0},
+		{"==", 0.5, 0.5, 1},
+		{"!=", 0.5, 0.5, 0},
+		{"&&", 3, -1, 1},
+		{"&&", 3, 0, 0},
+		{"||", 0, 0, 0},
+		{"||", 0, 2, 1},
+	}
+	for _, tt := range tests {
+		if got, err := Apply(tt.op, tt.a, tt.b); err != nil || got != tt.want {
+			t.Errorf("Apply(%v %s %v) = %v, %v, want %v", tt.a, tt.op, tt.b, got, err, tt.want)
+		}
+	}
+	if got := Match("<=3"); got != "<=" {
+		t.Errorf("Match(<=3) = %q, want <=", got)
+	}
+	if err := Register(Operation{Symbol: "?", Arity: 2, Precedence: 1, Eval: func(args ...float64) (float64, error) { return 0, nil }}); err == nil {
+		t.Error("Register() accepted the conditional symbol ?")
+	}
+}
@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/ops"
+)
+
+// Возвращает каноническую запись поддерева: числа нормализованы, а операнды
+// коммутативных операций (в реестре ops, например + и *) упорядочены, поэтому 2+3 и 3.0+2 дают один
+// ключ. Ассоциативность не используется: для float64 она не выполняется
+func CanonicalKey(node *models.Node) string {
+	if node == nil {
+		return ""
+	}
+	if IsConditional(node) {
+		return "(" + CanonicalKey(node.Cond) + "?" + CanonicalKey(node.Left) + ":" + CanonicalKey(node.Right) + ")"
+	}
+	if !IsOperator(node.Value) {
+		if num, err := strconv.ParseFloat(node.Value, 64); err == nil {
+			return formatNumber(num)
+		}
+		return node.Value
+	}
+	left, right := CanonicalKey(node.Left), CanonicalKey(node.Right)
+	if isCommutative(node.Value) && right < left {
+		left, right = right, left
+	}
+	var b strings.Builder
+	b.WriteString("(")
+	b.WriteString(left)
+	b.WriteString(node.Value)
+	b.WriteString(right)
+	b.WriteString(")")
+	return b.String()
+}
+
+// Возвращает хэш канонической записи поддерева
+func Hash(node *models.Node) string {
+	return hashKey(CanonicalKey(node))
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func isCommutative(symbol string) bool {
+	op, ok := ops.Lookup(symbol)
+	return ok && op.Commutative
+}
+
+// Заменяет числами поддеревья, результат которых уже известен. Поиск идёт
+// сверху вниз, поэтому найденное поддерево целиком превращается в одно число
+func Substitute(root *models.Node, lookup func(hash string) (float64, bool)) *models.Node {
+	if root == nil || !IsCompound(root.Value) {
+		return root
+	}
+	if value, ok := lookup(Hash(root)); ok {
+		return &models.Node{Value: formatNumber(value)}
+	}
+	return &models.Node{
+		Value: root.Value,
+		Cond:  Substitute(root.Cond, lookup),
+		Left:  Substitute(root.Left, lookup),
+		Right: Substitute(root.Right, lookup),
+	}
+}
@@ -0,0 +1,106 @@
+package parser
+
+import "github.com/NieR8/myProject/models"
+
+// Запись условия в RPN и в узле дерева: a ? b : c превращается в "a b c ?",
+// а узел "?" хранит условие в Cond и ветки в Left (истина) и Right (ложь)
+const Conditional = "?"
+
+// Является ли имя словом языка выражений (if) или записью операции из
+// реестра, например "mod": такие имена не могут быть переменными
+func IsKeyword(name string) bool {
+	return name == "if" || IsOperator(name)
+}
+
+// Является ли узел условием "?"
+func IsConditional(node *models.Node) bool {
+	return node != nil && node.Value == Conditional
+}
+
+// Вычисляется ли узел с такой записью: операция из реестра или условие, а не число
+func IsCompound(value string) bool {
+	return value == Conditional || IsOperator(value)
+}
+
+// Ветка условия, выбранная значением cond: ненулевое значение - истина
+func Branch(node *models.Node, cond float64) *models.Node {
+	if cond != 0 {
+		return node.Left
+	}
+	return node.Right
+}
+
+// Копирует дерево, чтобы его можно было менять, не трогая исходное
+func Clone(root *models.Node) *models.Node {
+	if root == nil {
+		return nil
+	}
+	return &models.Node{
+		Value:  root.Value,
+		Cond:   Clone(root.Cond),
+		Left:   Clone(root.Left),
+		Right:  Clone(root.Right),
+		TaskID: root.TaskID,
+	}
+}
+
+// Раскрывает if(c, a, b) в ((c)?(a):(b)); аргументы тоже могут содержать if
+func expandIf(tokens []string) ([]string, error) {
+	var out []string
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i] != "if" {
+			out = append(out, tokens[i])
+			continue
+		}
+		if i+1 >= len(tokens) || tokens[i+1] != "(" {
+			return nil, ErrInvalidExpression
+		}
+
+		// Делим аргументы по запятым верхнего уровня до закрывающей скобки if
+		var args [][]string
+		depth, start, end := 0, i+2, -1
+		for j := i + 1; j < len(tokens) && end < 0; j++ {
+			switch tokens[j] {
+			case "(":
+				depth++
+			case ")":
+				depth--
+				if depth == 0 {
+					args = append(args, tokens[start:j])
+					end = j
+				}
+			case ",":
+				if depth == 1 {
+					args = append(args, tokens[start:j])
+					start = j + 1
+				}
+			}
+		}
+		if end < 0 || len(args) != 3 {
+			return nil, ErrInvalidExpression // if принимает ровно три аргумента
+		}
+
+		out = append(out, "(")
+		for k, arg := range args {
+			if len(arg) == 0 {
+				return nil, ErrInvalidExpression
+			}
+			expanded, err := expandIf(arg)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, "(")
+			out = append(out, expanded...)
+			out = append(out, ")")
+			switch k {
+			case 0:
+				out = append(out, Conditional)
+			case 1:
+				out = append(out, ":")
+			}
+		}
+		out = append(out, ")")
+		i = end
+	}
+	return out, nil
+}
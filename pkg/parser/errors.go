@@ -1,11 +1,16 @@
 package parser
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/NieR8/myProject/pkg/ops"
+)
 
 var (
 	ErrInvalidSymbol     = fmt.Errorf("invalid symbol in expression")
 	ErrInvalidExpression = fmt.Errorf("invalid expression")
 	ErrEmptyExpression   = fmt.Errorf("empty expression")
 	ErrInvalidRpn        = fmt.Errorf("invalid RPN expression")
-	ErrDivisionByZero    = fmt.Errorf("division by zero")
+	ErrDivisionByZero    = ops.ErrDivisionByZero
+	ErrNotInteger        = ops.ErrNotInteger
 )
@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/ops"
+)
+
+// Рекурсивно вычисляет значение дерева операций локально, без агентов.
+// У условия считается только выбранная ветка
+func Evaluate(node *models.Node) (float64, error) {
+	if node == nil {
+		return 0, fmt.Errorf("nil node")
+	}
+
+	if IsConditional(node) {
+		cond, err := Evaluate(node.Cond)
+		if err != nil {
+			return 0, err
+		}
+		return Evaluate(Branch(node, cond))
+	}
+
+	if !IsOperator(node.Value) {
+		return strconv.ParseFloat(node.Value, 64)
+	}
+
+	leftVal, err := Evaluate(node.Left)
+	if err != nil {
+		return 0, err
+	}
+
+	rightVal, err := Evaluate(node.Right)
+	if err != nil {
+		return 0, err
+	}
+
+	return ops.Apply(node.Value, leftVal, rightVal)
+}
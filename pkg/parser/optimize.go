@@ -0,0 +1,155 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/ops"
+)
+
+// Упрощает дерево операций перед формированием задач: сворачивает константы
+// и применяет алгебраические тождества (x+0, x-0, x*1, x/1, x*0).
+// Условие с известным значением заменяется выбранной веткой.
+// Исходное дерево не изменяется, возвращается новое.
+func Optimize(root *models.Node) *models.Node {
+	return optimize(root, true)
+}
+
+// Применяет только алгебраические тождества и выбор ветки по известному
+// условию, не сворачивая константы: операции над двумя числами остаются
+// задачами для агентов
+func Simplify(root *models.Node) *models.Node {
+	return optimize(root, false)
+}
+
+func optimize(root *models.Node, foldConstants bool) *models.Node {
+	if root == nil {
+		return nil
+	}
+	if IsConditional(root) {
+		cond := optimize(root.Cond, foldConstants)
+		if value, ok := literal(cond); ok {
+			return optimize(Branch(root, value), foldConstants) // Невыбранная ветка не считается вовсе
+		}
+		return &models.Node{
+			Value: root.Value,
+			Cond:  cond,
+			Left:  optimize(root.Left, foldConstants),
+			Right: optimize(root.Right, foldConstants),
+		}
+	}
+	if !IsOperator(root.Value) {
+		return &models.Node{Value: root.Value}
+	}
+
+	left := optimize(root.Left, foldConstants)
+	right := optimize(root.Right, foldConstants)
+	node := &models.Node{Value: root.Value, Left: left, Right: right}
+
+	leftNum, leftIsNum := literal(left)
+	rightNum, rightIsNum := literal(right)
+
+	// Оба операнда - числа: считаем сразу, без агента
+	if foldConstants && leftIsNum && rightIsNum {
+		if value, ok := fold(node.Value, leftNum, rightNum); ok {
+			return &models.Node{Value: formatNumber(value)}
+		}
+		return node // Например, деление на ноль - оставляем для BuildTasks
+	}
+
+	switch node.Value {
+	case "+":
+		if rightIsNum && rightNum == 0 {
+			return left
+		}
+		if leftIsNum && leftNum == 0 {
+			return right
+		}
+	case "-":
+		if rightIsNum && rightNum == 0 {
+			return left
+		}
+	case "*":
+		if rightIsNum && rightNum == 1 {
+			return left
+		}
+		if leftIsNum && leftNum == 1 {
+			return right
+		}
+		// x*0 = 0 только если x гарантированно конечен: Inf*0 и NaN*0 дают NaN,
+		// а деление внутри x может завершиться ошибкой, которую нельзя терять
+		if rightIsNum && rightNum == 0 && isFinite(left) {
+			return &models.Node{Value: formatNumber(0)}
+		}
+		if leftIsNum && leftNum == 0 && isFinite(right) {
+			return &models.Node{Value: formatNumber(0)}
+		}
+	case "/":
+		if rightIsNum && rightNum == 1 {
+			return left
+		}
+	}
+
+	return node
+}
+
+// Возвращает значение узла, если это число
+func literal(node *models.Node) (float64, bool) {
+	if node == nil || IsCompound(node.Value) {
+		return 0, false
+	}
+	num, err := strconv.ParseFloat(node.Value, 64)
+	if err != nil {
+		return 0, false
+	}
+	return num, true
+}
+
+// Вычисляет операцию над двумя числами. Не сворачивает деление на ноль
+// и результаты, которые не являются конечными числами
+func fold(op string, a, b float64) (float64, bool) {
+	value, err := ops.Apply(op, a, b)
+	if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, false
+	}
+	return value, true
+}
+
+// Проверяет, что значение поддерева гарантированно конечно: оценивает
+// сверху модуль результата и не допускает деления
+func isFinite(node *models.Node) bool {
+	bound := magnitudeBound(node)
+	return !math.IsInf(bound, 0) && !math.IsNaN(bound)
+}
+
+// Возвращает верхнюю оценку модуля значения поддерева
+func magnitudeBound(node *models.Node) float64 {
+	if node == nil {
+		return math.Inf(1)
+	}
+	if IsConditional(node) {
+		return math.Inf(1) // Условие может упасть с ошибкой, которую нельзя терять
+	}
+	if !IsOperator(node.Value) {
+		num, err := strconv.ParseFloat(node.Value, 64)
+		if err != nil {
+			return math.Inf(1)
+		}
+		return math.Abs(num)
+	}
+	left := magnitudeBound(node.Left)
+	right := magnitudeBound(node.Right)
+	switch node.Value {
+	case "+", "-":
+		return left + right
+	case "*":
+		return left * right
+	default:
+		return math.Inf(1)
+	}
+}
+
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/ops"
 	"log"
 	"strconv"
 	"strings"
@@ -18,32 +19,42 @@ func CheckExpression(str []string) bool {
 	return true
 }
 
-// Разбивает строку на токены (числа и операторы)
+// Разбивает строку на токены (числа, скобки, операторы из реестра ops,
+// части условия ? : и if с запятыми между аргументами)
 func tokenize(expression string) ([]string, error) {
 	var tokens []string
 	var current strings.Builder
 	expression = strings.ReplaceAll(expression, " ", "") // Удаляем пробелы
 
-	for i, char := range expression {
+	for i := 0; i < len(expression); {
+		char := expression[i]
 		if char >= '0' && char <= '9' || char == '.' {
-			current.WriteRune(char)
-		} else {
-			if current.Len() > 0 {
-				tokens = append(tokens, current.String())
-				if current.Len() > 1 && current.String()[0] == '0' && current.String()[1] != '.' {
-					return nil, ErrInvalidSymbol // Проверка на ведущий ноль
-				}
-				current.Reset()
-			}
-			if char == '+' || char == '-' || char == '*' || char == '/' || char == '(' || char == ')' {
-				if char == '-' && (i == 0 || (i > 0 && expression[i-1] == '(')) {
-					current.WriteRune(char) // Унарный минус
-				} else {
-					tokens = append(tokens, string(char))
-				}
-			} else {
-				return nil, ErrInvalidSymbol // Недопустимый символ
+			current.WriteByte(char)
+			i++
+			continue
+		}
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			if current.Len() > 1 && current.String()[0] == '0' && current.String()[1] != '.' {
+				return nil, ErrInvalidSymbol // Проверка на ведущий ноль
 			}
+			current.Reset()
+		}
+		switch op := ops.Match(expression[i:]); {
+		case strings.ContainsRune("()?:,", rune(char)):
+			tokens = append(tokens, string(char))
+			i++
+		case strings.HasPrefix(expression[i:], "if("):
+			tokens = append(tokens, "if")
+			i += len("if")
+		case op == "-" && unaryMinusAllowed(tokens):
+			current.WriteByte(char) // Унарный минус
+			i++
+		case op != "":
+			tokens = append(tokens, op) // Самая длинная подходящая запись: // раньше /
+			i += len(op)
+		default:
+			return nil, ErrInvalidSymbol // Недопустимый символ
 		}
 	}
 	if current.Len() > 0 {
@@ -60,7 +71,21 @@ func tokenize(expression string) ([]string, error) {
 		return nil, ErrInvalidExpression
 	}
 
-	return tokens, nil
+	return expandIf(tokens)
+}
+
+// Может ли после этих токенов идти унарный минус: в начале выражения, после
+// открывающей скобки, частей условия, запятой и любой двухместной операции
+func unaryMinusAllowed(tokens []string) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	switch prev := tokens[len(tokens)-1]; prev {
+	case "(", Conditional, ":", ",":
+		return true
+	default:
+		return IsOperator(prev)
+	}
 }
 
 // Преобразует выражение из инфиксной записи в постфиксную (RPN)
@@ -73,13 +98,6 @@ func InfixToRPN(expression string) (string, error) {
 	var output []string
 	var stack []string
 
-	precedence := map[string]int{
-		"+": 1,
-		"-": 1,
-		"*": 2,
-		"/": 2,
-	}
-
 	for _, token := range tokens {
 		if _, err := strconv.ParseFloat(token, 64); err == nil {
 			output = append(output, token)
@@ -87,15 +105,46 @@ func InfixToRPN(expression string) (string, error) {
 			stack = append(stack, token)
 		} else if token == ")" {
 			for len(stack) > 0 && stack[len(stack)-1] != "(" {
-				output = append(output, stack[len(stack)-1])
+				if stack[len(stack)-1] == Conditional {
+					return "", ErrInvalidExpression // ? без :
+				}
+				output = append(output, rpnToken(stack[len(stack)-1]))
 				stack = stack[:len(stack)-1]
 			}
 			if len(stack) == 0 {
 				return "", ErrInvalidExpression // Нет открывающей скобки
 			}
 			stack = stack[:len(stack)-1]
+		} else if token == Conditional {
+			// Условие слабее всех операций: выталкиваем их до скобки или
+			// предыдущего условия, оно правоассоциативно
+			for len(stack) > 0 && IsOperator(stack[len(stack)-1]) {
+				output = append(output, stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			}
+			stack = append(stack, token)
+		} else if token == ":" {
+			// Ветка "истина" закончилась: выталкиваем её операции и вложенные
+			// условия, а ? заменяем на :, ждущий ветку "ложь"
+			for len(stack) > 0 && (IsOperator(stack[len(stack)-1]) || stack[len(stack)-1] == ":") {
+				output = append(output, rpnToken(stack[len(stack)-1]))
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 || stack[len(stack)-1] != Conditional {
+				return "", ErrInvalidExpression // : без ?
+			}
+			stack[len(stack)-1] = token
+		} else if !IsOperator(token) {
+			return "", ErrInvalidExpression // Например, запятая вне if
 		} else {
-			for len(stack) > 0 && precedence[stack[len(stack)-1]] >= precedence[token] {
+			// Выталкиваем операторы, которые выполняются раньше: с большим приоритетом
+			// или с тем же, если token левоассоциативный
+			op, _ := ops.Lookup(token)
+			for len(stack) > 0 {
+				top, isOp := ops.Lookup(stack[len(stack)-1])
+				if !isOp || top.Precedence < op.Precedence || top.Precedence == op.Precedence && op.Associativity == ops.RightAssoc {
+					break
+				}
 				output = append(output, stack[len(stack)-1])
 				stack = stack[:len(stack)-1]
 			}
@@ -104,16 +153,24 @@ func InfixToRPN(expression string) (string, error) {
 	}
 
 	for len(stack) > 0 {
-		if stack[len(stack)-1] == "(" {
-			return "", ErrInvalidExpression // Нет закрывающей скобки
+		if stack[len(stack)-1] == "(" || stack[len(stack)-1] == Conditional {
+			return "", ErrInvalidExpression // Нет закрывающей скобки или :
 		}
-		output = append(output, stack[len(stack)-1])
+		output = append(output, rpnToken(stack[len(stack)-1]))
 		stack = stack[:len(stack)-1]
 	}
 
 	return strings.Join(output, " "), nil
 }
 
+// Запись токена со стека в RPN: полное условие (:) записывается как ?
+func rpnToken(token string) string {
+	if token == ":" {
+		return Conditional
+	}
+	return token
+}
+
 // Парсит RPN выражение и строит дерево операций
 func ParseRPN(rpn string) (*models.Node, error) {
 	if rpn == "" {
@@ -124,7 +181,14 @@ func ParseRPN(rpn string) (*models.Node, error) {
 	stack := make([]*models.Node, 0)
 
 	for _, token := range tokens {
-		if IsOperator(token) {
+		if token == Conditional {
+			if len(stack) < 3 {
+				return nil, ErrInvalidRpn
+			}
+			cond, then, otherwise := stack[len(stack)-3], stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-3]
+			stack = append(stack, &models.Node{Value: token, Cond: cond, Left: then, Right: otherwise})
+		} else if IsOperator(token) {
 			if len(stack) < 2 {
 				return nil, ErrInvalidRpn
 			}
@@ -151,18 +215,58 @@ func ParseRPN(rpn string) (*models.Node, error) {
 	return stack[0], nil
 }
 
+// Является ли токен операцией из реестра ops
 func IsOperator(token string) bool {
-	return token == "+" || token == "-" || token == "*" || token == "/"
+	return ops.IsOperator(token)
 }
 
-// Строит список задач на основе дерева
+// Строит список задач на основе дерева. Одинаковые поддеревья превращаются
+// в одну общую задачу, на которую ссылаются несколько родителей. Узлам
+// дерева проставляется ID вычисляющей их задачи.
+// Условие с известным значением сразу заменяется выбранной веткой. Иначе
+// для него строится только задача условия "?" (Arg1 - задача, считающая
+// условие, Arg2 пуст): ветки не разбираются, пока условие не посчитано,
+// хранилище строит задачи выбранной ветки через BuildTasksFrom
 func BuildTasks(exprID string, root *models.Node) ([]models.Task, error) {
+	_, tasks, err := BuildTasksFrom(exprID, root, 0, nil)
+	return tasks, err
+}
+
+// Отклоняет операнды-числа, которые операция заведомо не примет: ноль
+// справа у деления, дробное число у // и %. Операнды, которые посчитают
+// другие задачи, проверяет агент
+func checkLiterals(op ops.Operation, node *models.Node) error {
+	if op.IntegerOperands {
+		for _, arg := range []*models.Node{node.Left, node.Right} {
+			if value, err := strconv.ParseFloat(arg.Value, 64); err == nil {
+				if err := ops.CheckInteger(value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if rightNum, err := strconv.ParseFloat(node.Right.Value, 64); err == nil && op.NonZeroDivisor && rightNum == 0 {
+		return ErrDivisionByZero
+	}
+	return nil
+}
+
+// То же, что BuildTasks, но номера задач начинаются с first, чтобы не
+// совпасть с уже выданными задачами выражения. built - уже созданные задачи
+// выражения по Hash: поддерево, которое уже считается, новой задачи не
+// получает. Новые задачи дописываются в built, nil - общих задач нет.
+// Возвращает и аргумент для родителя: ID корневой задачи или число, если
+// задач не понадобилось
+func BuildTasksFrom(exprID string, root *models.Node, first int, built map[string]string) (string, []models.Task, error) {
 	if root == nil {
-		return nil, ErrEmptyExpression
+		return "", nil, ErrEmptyExpression
 	}
 
 	var tasks []models.Task
-	var taskCounter int
+	taskCounter := first
+	if built == nil {
+		built = make(map[string]string) // Hash поддерева -> ID уже созданной задачи
+	}
 
 	var buildTask func(node *models.Node) (string, error)
 	buildTask = func(node *models.Node) (string, error) {
@@ -170,21 +274,38 @@ func BuildTasks(exprID string, root *models.Node) ([]models.Task, error) {
 			return "", nil
 		}
 
-		if !IsOperator(node.Value) {
+		if IsConditional(node) {
+			if cond, ok := literal(node.Cond); ok {
+				arg, err := buildTask(Branch(node, cond))
+				if err == nil && !isNumeric(arg) {
+					node.TaskID = arg
+				}
+				return arg, err
+			}
+		} else if !IsOperator(node.Value) {
 			return node.Value, nil // Число
+		} else if op, _ := ops.Lookup(node.Value); op.NonZeroDivisor || op.IntegerOperands {
+			if err := checkLiterals(op, node); err != nil {
+				return "", err
+			}
 		}
 
-		if node.Value == "/" {
-			if rightNum, err := strconv.ParseFloat(node.Right.Value, 64); err == nil && rightNum == 0 {
-				return "", ErrDivisionByZero
-			}
+		key := hashKey(CanonicalKey(node))
+		if taskID, ok := built[key]; ok {
+			node.TaskID = taskID
+			return taskID, nil
 		}
 
-		leftArg, err := buildTask(node.Left)
-		if err != nil {
-			return "", err
+		var leftArg, rightArg string
+		var err error
+		if IsConditional(node) {
+			leftArg, err = buildTask(node.Cond) // Ветки ждут результата условия
+		} else {
+			leftArg, err = buildTask(node.Left)
+			if err == nil {
+				rightArg, err = buildTask(node.Right)
+			}
 		}
-		rightArg, err := buildTask(node.Right)
 		if err != nil {
 			return "", err
 		}
@@ -198,19 +319,27 @@ func BuildTasks(exprID string, root *models.Node) ([]models.Task, error) {
 			Arg2:      rightArg,
 			Operation: node.Value,
 			Completed: false,
+			Hash:      key,
 		}
 		tasks = append(tasks, task)
+		built[key] = taskID
+		node.TaskID = taskID
 		return taskID, nil
 	}
 
-	_, err := buildTask(root)
+	rootArg, err := buildTask(root)
 	if err != nil {
-		return nil, err // Возвращаем ошибку
+		return "", nil, err // Возвращаем ошибку
 	}
 
 	for i, j := 0, len(tasks)-1; i < j; i, j = i+1, j-1 {
 		tasks[i], tasks[j] = tasks[j], tasks[i] // Разворачиваем задачи, чтобы дерево считалось снизу вверх
 	}
 	log.Printf("Сформированы задачи для %s: %+v", exprID, tasks)
-	return tasks, nil
+	return rootArg, tasks, nil
+}
+
+func isNumeric(arg string) bool {
+	_, err := strconv.ParseFloat(arg, 64)
+	return err == nil
 }
@@ -1,8 +1,12 @@
 package parser
 
 import (
+	"errors"
 	"github.com/NieR8/myProject/models"
+	"github.com/NieR8/myProject/pkg/ops"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestInfixToRPN(t *testing.T) {
@@ -13,6 +17,11 @@ func TestInfixToRPN(t *testing.T) {
 		{"2+3", "2 3 +"},
 		{"(5+2)+4/5", "5 2 + 4 5 / +"},
 		{"2++3", "2 + 3 +"},
+		{"7//2*3%4", "7 2 // 3 * 4 %"},
+		{"1+10%3", "1 10 3 % +"},
+		{"7 % -2", "7 -2 %"},
+		{"7 // - 2*-3", "7 -2 // -3 *"},
+		{"2--3", "2 -3 -"},
 	}
 
 	for _, tt := range tests {
@@ -72,3 +81,283 @@ func TestBuildTasks(t *testing.T) {
 		})
 	}
 }
+
+func TestOptimize(t *testing.T) {
+	x := &models.Node{Value: "+", Left: &models.Node{Value: "a"}, Right: &models.Node{Value: "b"}}
+	tests := []struct {
+		name     string
+		root     *models.Node
+		expected string
+	}{
+		{"constants", &models.Node{Value: "+", Left: &models.Node{Value: "2"}, Right: &models.Node{Value: "3"}}, "5"},
+		{"x*1", &models.Node{Value: "*", Left: x, Right: &models.Node{Value: "1"}}, "(a+b)"},
+		{"0+x", &models.Node{Value: "+", Left: &models.Node{Value: "0"}, Right: x}, "(a+b)"},
+		{"x/1", &models.Node{Value: "/", Left: x, Right: &models.Node{Value: "1.000000"}}, "(a+b)"},
+		{"symbol*0", &models.Node{Value: "*", Left: x, Right: &models.Node{Value: "0"}}, "((a+b)*0)"},
+		{"division*0", &models.Node{Value: "*", Left: &models.Node{Value: "/", Left: &models.Node{Value: "7"}, Right: &models.Node{Value: "0"}}, Right: &models.Node{Value: "0"}}, "((7/0)*0)"},
+		{"overflow*0", &models.Node{Value: "*", Left: &models.Node{Value: "*", Left: &models.Node{Value: "1e300"}, Right: &models.Node{Value: "1e300"}}, Right: &models.Node{Value: "0"}}, ""},
+		{"finite*0", &models.Node{Value: "*", Left: &models.Node{Value: "0"}, Right: &models.Node{Value: "-", Left: &models.Node{Value: "7"}, Right: &models.Node{Value: "2"}}}, "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CanonicalKey(Optimize(tt.root))
+			if tt.expected == "" {
+				tt.expected = CanonicalKey(tt.root) // Дерево должно остаться без изменений
+			}
+			if got != tt.expected {
+				t.Errorf("Optimize(%s) = %s, want %s", CanonicalKey(tt.root), got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildTasksSharesSubtrees(t *testing.T) {
+	rpn, err := InfixToRPN("(2+3)*(2+3)")
+	if err != nil {
+		t.Fatalf("InfixToRPN unexpected error: %v", err)
+	}
+	tree, err := ParseRPN(rpn)
+	if err != nil {
+		t.Fatalf("ParseRPN unexpected error: %v", err)
+	}
+
+	tasks, err := BuildTasks("expr-1", tree)
+	if err != nil {
+		t.Fatalf("BuildTasks unexpected error: %v", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("BuildTasks returned %d tasks, want 2: %+v", len(tasks), tasks)
+	}
+	root := tasks[0]
+	if root.Arg1 != tasks[1].ID || root.Arg2 != tasks[1].ID {
+		t.Errorf("root task %+v should reference shared task %s twice", root, tasks[1].ID)
+	}
+}
+
+func TestCanonicalKeyAndSubstitute(t *testing.T) {
+	a := &models.Node{Value: "*", Left: &models.Node{Value: "2.000000"}, Right: &models.Node{Value: "x"}}
+	b := &models.Node{Value: "*", Left: &models.Node{Value: "x"}, Right: &models.Node{Value: "2"}}
+	if Hash(a) != Hash(b) {
+		t.Errorf("Hash(%s) != Hash(%s), commutative operands must give one hash", CanonicalKey(a), CanonicalKey(b))
+	}
+	c := &models.Node{Value: "-", Left: &models.Node{Value: "x"}, Right: &models.Node{Value: "2"}}
+	d := &models.Node{Value: "-", Left: &models.Node{Value: "2"}, Right: &models.Node{Value: "x"}}
+	if Hash(c) == Hash(d) {
+		t.Errorf("Hash(%s) == Hash(%s), subtraction is not commutative", CanonicalKey(c), CanonicalKey(d))
+	}
+
+	root := &models.Node{Value: "+", Left: b, Right: c}
+	got := Substitute(root, func(hash string) (float64, bool) {
+		return 6, hash == Hash(a)
+	})
+	if key := CanonicalKey(got); key != "((x-2)+6)" {
+		t.Errorf("Substitute() = %s, want ((x-2)+6)", key)
+	}
+}
+
+func TestCustomOperation(t *testing.T) {
+	// Одна регистрация - и оператор понимают разбор, вычисление и задачи
+	ops.MustRegister(ops.Operation{
+		Symbol: "**", Arity: 2, Precedence: 7, Associativity: ops.RightAssoc, DefaultCost: time.Millisecond,
+		Eval: func(args ...float64) (float64, error) { return math.Pow(args[0], args[1]), nil },
+	})
+
+	rpn, err := InfixToRPN("2**3**2*2")
+	if err != nil || rpn != "2 3 2 ** ** 2 *" {
+		t.Fatalf("InfixToRPN() = %q, %v, want right-associative ** above *", rpn, err)
+	}
+	tree, _ := ParseRPN(rpn)
+	if value, err := Evaluate(tree); err != nil || value != 1024 {
+		t.Errorf("Evaluate() = %v, %v, want 1024", value, err)
+	}
+	if tasks, err := BuildTasks("expr-1", tree); err != nil || len(tasks) != 3 || tasks[2].Operation != "**" {
+		t.Errorf("BuildTasks() = %+v, %v", tasks, err)
+	}
+}
+
+func TestBuildTasksRejectsZeroDivisor(t *testing.T) {
+	for _, expr := range []string{"5/0", "5//0", "5%(0)", "1+5%0"} {
+		rpn, err := InfixToRPN(expr)
+		if err != nil {
+			t.Fatalf("InfixToRPN(%q): %v", expr, err)
+		}
+		tree, _ := ParseRPN(rpn)
+		if _, err := BuildTasks("expr-1", tree); !errors.Is(err, ErrDivisionByZero) {
+			t.Errorf("BuildTasks(%q) = %v, want ErrDivisionByZero", expr, err)
+		}
+	}
+}
+
+func TestBuildTasksRejectsNonIntegerOperands(t *testing.T) {
+	for _, expr := range []string{"7.5//2", "7%0.5", "1+2.5%2", "9007199254740994//2", "5.5%0"} {
+		rpn, _ := InfixToRPN(expr)
+		tree, _ := ParseRPN(rpn)
+		if _, err := BuildTasks("expr-1", tree); !errors.Is(err, ErrNotInteger) {
+			t.Errorf("BuildTasks(%q) = %v, want ErrNotInteger", expr, err)
+		}
+	}
+
+	// Дробный операнд, который посчитает другая задача, проверяет агент
+	rpn, _ := InfixToRPN("(1.5+1.5)//2")
+	tree, _ := ParseRPN(rpn)
+	if tasks, err := BuildTasks("expr-1", tree); err != nil || len(tasks) != 2 {
+		t.Errorf("BuildTasks((1.5+1.5)//2) = %+v, %v, want 2 tasks", tasks, err)
+	}
+}
+
+func TestConditionalExpressions(t *testing.T) {
+	tests := []struct {
+		input string
+		rpn   string
+		want  float64
+	}{
+		{"2>1", "2 1 >", 1},
+		{"1+2<=3&&4!=4||1", "1 2 + 3 <= 4 4 != && 1 ||", 1},
+		{"1>0?2:3", "1 0 > 2 3 ?", 2},
+		{"5>=7&&1!=0?5/1:0", "5 7 >= 1 0 != && 5 1 / 0 ?", 0},
+		{"0?1:0?2:3", "0 1 0 2 3 ? ?", 3},
+		{"1?0?2:3:4", "1 0 2 3 ? 4 ?", 3},
+		{"(1?2:3)*4", "1 2 3 ? 4 *", 8},
+		{"if(150>100,150*0.9,150)", "150 100 > 150 0.9 * 150 ?", 135},
+		{"if(1,if(0,1,2),-3)+1", "1 0 1 2 ? -3 ? 1 +", 3},
+		{"-1<0?-1:1", "-1 0 < -1 1 ?", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			rpn, err := InfixToRPN(tt.input)
+			if err != nil || rpn != tt.rpn {
+				t.Fatalf("InfixToRPN(%q) = %q, %v, want %q", tt.input, rpn, err, tt.rpn)
+			}
+			tree, err := ParseRPN(rpn)
+			if err != nil {
+				t.Fatalf("ParseRPN(%q): %v", rpn, err)
+			}
+			if value, err := Evaluate(tree); err != nil || value != tt.want {
+				t.Errorf("Evaluate() = %v, %v, want %v", value, err, tt.want)
+			}
+			if folded, ok := literal(Optimize(tree)); !ok || folded != tt.want {
+				t.Errorf("Optimize() = %v, %v, want %v", folded, ok, tt.want)
+			}
+		})
+	}
+
+	for _, input := range []string{"1?2", "1:2", "1?2:3:4", "(1?2)", "if(1,2)", "if(1,2,3,4)", "if 1", "1,2", "if(,1,2)"} {
+		if rpn, err := InfixToRPN(input); err == nil {
+			if _, err := ParseRPN(rpn); err == nil {
+				t.Errorf("InfixToRPN(%q) = %q, want error", input, rpn)
+			}
+		}
+	}
+}
+
+func TestBuildTasksLazyConditional(t *testing.T) {
+	rpn, _ := InfixToRPN("2+3>4?1/0:6*7")
+	tree, _ := ParseRPN(rpn)
+
+	// Деление на ноль в ветке не мешает: ветки не разбираются до условия
+	tasks, err := BuildTasks("expr-1", tree)
+	if err != nil {
+		t.Fatalf("BuildTasks() error: %v", err)
+	}
+	want := []models.Task{
+		{ID: "task-expr-1-2", Arg1: "task-expr-1-1", Operation: Conditional},
+		{ID: "task-expr-1-1", Arg1: "task-expr-1-0", Arg2: "4.000000", Operation: ">"},
+		{ID: "task-expr-1-0", Arg1: "2.000000", Arg2: "3.000000", Operation: "+"},
+	}
+	if len(tasks) != len(want) {
+		t.Fatalf("BuildTasks() = %+v, want %d tasks", tasks, len(want))
+	}
+	for i, task := range tasks {
+		if task.ID != want[i].ID || task.Arg1 != want[i].Arg1 || task.Arg2 != want[i].Arg2 || task.Operation != want[i].Operation {
+			t.Errorf("task %d = %+v, want %+v", i, task, want[i])
+		}
+	}
+	if tree.TaskID != "task-expr-1-2" || tree.Left.TaskID != "" {
+		t.Errorf("TaskID of condition = %q, of branch = %q", tree.TaskID, tree.Left.TaskID)
+	}
+
+	// Задачи ветки продолжают нумерацию выражения
+	arg, branch, err := BuildTasksFrom("expr-1", Branch(tree, 0), 3, nil)
+	if err != nil || arg != "task-expr-1-3" || len(branch) != 1 || branch[0].Operation != "*" {
+		t.Errorf("BuildTasksFrom() = %q, %+v, %v", arg, branch, err)
+	}
+	if _, _, err := BuildTasksFrom("expr-1", Branch(tree, 1), 3, nil); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("BuildTasksFrom(1/0) = %v, want ErrDivisionByZero", err)
+	}
+
+	// Ветка делит задачи с уже построенными одинаковыми поддеревьями
+	rpn, _ = InfixToRPN("2+3>4?(2+3)*7:0")
+	tree, _ = ParseRPN(rpn)
+	tasks, _ = BuildTasks("expr-3", tree)
+	built := make(map[string]string)
+	for _, task := range tasks {
+		built[task.Hash] = task.ID
+	}
+	arg, branch, err = BuildTasksFrom("expr-3", Branch(tree, 1), 3, built)
+	if err != nil || len(branch) != 1 || branch[0].Arg1 != "task-expr-3-0" || built[branch[0].Hash] != arg {
+		t.Errorf("BuildTasksFrom() with shared tasks = %q, %+v, %v", arg, branch, err)
+	}
+
+	// Известное условие сразу заменяется веткой
+	rpn, _ = InfixToRPN("if(1, 2*3, 1/0)")
+	tree, _ = ParseRPN(rpn)
+	if tasks, err := BuildTasks("expr-2", tree); err != nil || len(tasks) != 1 || tasks[0].Operation != "*" {
+		t.Errorf("BuildTasks(if(1, ...)) = %+v, %v", tasks, err)
+	}
+}
+
+func TestSpacesAreIgnored(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"1 2", 12},
+		{"1 . 5 * 2", 3},
+		{"7 / / 2", 3},
+		{"7 % -2", -1},
+		{"7 // - 2", -4},
+		{"if (1 > 0, 1, 2)", 1},
+	}
+	for _, tt := range tests {
+		rpn, err := InfixToRPN(tt.input)
+		if err != nil {
+			t.Errorf("InfixToRPN(%q) error: %v", tt.input, err)
+			continue
+		}
+		tree, err := ParseRPN(rpn)
+		if err != nil {
+			t.Errorf("ParseRPN(%q) error: %v", rpn, err)
+			continue
+		}
+		if value, err := Evaluate(tree); err != nil || value != tt.want {
+			t.Errorf("Evaluate(%q) = %v, %v, want %v", tt.input, value, err, tt.want)
+		}
+	}
+}
+
+func TestUnaryMinusInConditions(t *testing.T) {
+	tests := []struct {
+		input string
+		rpn   string
+		want  float64
+	}{
+		{"2 > -1", "2 -1 >", 1},
+		{"-3 <= -4", "-3 -4 <=", 0},
+		{"1 && -2", "1 -2 &&", 1},
+		{"0 || -1 == -1", "0 -1 -1 == ||", 1},
+		{"0 ? -1 : 1", "0 -1 1 ?", 1},
+		{"if(1, -1, -2)", "1 -1 -2 ?", -1},
+	}
+	for _, tt := range tests {
+		rpn, err := InfixToRPN(tt.input)
+		if err != nil || rpn != tt.rpn {
+			t.Errorf("InfixToRPN(%q) = %q, %v, want %q", tt.input, rpn, err, tt.rpn)
+			continue
+		}
+		tree, _ := ParseRPN(rpn)
+		if value, err := Evaluate(tree); err != nil || value != tt.want {
+			t.Errorf("Evaluate(%q) = %v, %v, want %v", tt.input, value, err, tt.want)
+		}
+	}
+}